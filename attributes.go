@@ -0,0 +1,370 @@
+package gus
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+type AttrType string
+
+const (
+	AttrString AttrType = "string"
+	AttrInt    AttrType = "int" // Stored as a double so exact up to 2^53.
+	AttrFloat  AttrType = "float"
+	AttrBool   AttrType = "bool"
+)
+
+// maxAttrLength is the longest string attribute, the size of the str_value column.
+const maxAttrLength = 1024
+
+var attrName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// AttributeSchema declares custom attributes and their types, names are lower case letters, digits and
+// underscores. Attributes which aren't declared can't be set.
+type AttributeSchema map[string]AttrType
+
+// Attributes are the values of custom attributes by name, setting one to nil in an update removes it.
+type Attributes map[string]interface{}
+
+func (as Attributes) clone() Attributes {
+	if as == nil {
+		return nil
+	}
+	c := make(Attributes, len(as))
+	for k, v := range as {
+		c[k] = v
+	}
+	return c
+}
+
+// merge returns a copy of the attributes with the updates applied.
+func (as Attributes) merge(updates Attributes) Attributes {
+	m := as.clone()
+	if m == nil {
+		m = Attributes{}
+	}
+	for k, v := range updates {
+		m[k] = v
+	}
+	return m.set()
+}
+
+// set returns the attributes which aren't nil, or nil if there are none.
+func (as Attributes) set() Attributes {
+	var s Attributes
+	for k, v := range as {
+		if v != nil {
+			if s == nil {
+				s = Attributes{}
+			}
+			s[k] = v
+		}
+	}
+	return s
+}
+
+// String returns the named string attribute, ok is false if it isn't set or isn't a string.
+func (as Attributes) String(name string) (string, bool) {
+	v, ok := as[name].(string)
+	return v, ok
+}
+
+// Int returns the named int attribute, ok is false if it isn't set or isn't a whole number.
+func (as Attributes) Int(name string) (int64, bool) {
+	v, ok := AttrInt.convert(as[name])
+	if !ok {
+		return 0, false
+	}
+	return v.(int64), true
+}
+
+// Float returns the named float attribute, ok is false if it isn't set or isn't a number.
+func (as Attributes) Float(name string) (float64, bool) {
+	v, ok := AttrFloat.convert(as[name])
+	if !ok {
+		return 0, false
+	}
+	return v.(float64), true
+}
+
+// Bool returns the named bool attribute, ok is false if it isn't set or isn't a bool.
+func (as Attributes) Bool(name string) (bool, bool) {
+	v, ok := as[name].(bool)
+	return v, ok
+}
+
+// check returns the attributes converted to the declared types or a ValidationError naming the first bad one.
+func (s AttributeSchema) check(as Attributes) (Attributes, error) {
+	checked := Attributes{}
+	for name, v := range as {
+		t, ok := s[name]
+		if !ok || !attrName.MatchString(name) {
+			return nil, ErrInvalid(fmt.Sprintf("Unknown attribute '%s'.", name))
+		}
+		if v == nil {
+			checked[name] = nil
+			continue
+		}
+		cv, ok := t.convert(v)
+		if !ok {
+			return nil, ErrInvalid(fmt.Sprintf("Attribute '%s' must be of type %s.", name, t))
+		}
+		checked[name] = cv
+	}
+	return checked, nil
+}
+
+func (t AttrType) convert(v interface{}) (interface{}, bool) {
+	if n, ok := v.(json.Number); ok {
+		f, err := n.Float64()
+		if err != nil {
+			return nil, false
+		}
+		v = f
+	}
+	switch t {
+	case AttrString:
+		s, ok := v.(string)
+		return s, ok && len(s) <= maxAttrLength
+	case AttrBool:
+		b, ok := v.(bool)
+		return b, ok
+	case AttrInt:
+		switch n := v.(type) {
+		case int:
+			return int64(n), true
+		case int64:
+			return n, true
+		case float64:
+			return int64(n), n == math.Trunc(n)
+		}
+	case AttrFloat:
+		switch n := v.(type) {
+		case int:
+			return float64(n), true
+		case int64:
+			return float64(n), true
+		case float64:
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// attrStore keeps the attributes of users or orgs in a table of name, value rows.
+type attrStore struct {
+	table string
+	owner string
+}
+
+var (
+	userAttrs = attrStore{table: "user_attributes", owner: "user_id"}
+	orgAttrs  = attrStore{table: "org_attributes", owner: "org_id"}
+)
+
+// save sets the attributes of the owner, nil values are deleted.
+func (s attrStore) save(q queryer, id int64, as Attributes) error {
+	for name, v := range as {
+		_, err := q.Exec("DELETE FROM "+s.table+" WHERE "+s.owner+" = ? AND name = ?", id, name)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			continue
+		}
+		var str sql.NullString
+		var num sql.NullFloat64
+		switch val := v.(type) {
+		case string:
+			str = sql.NullString{String: val, Valid: true}
+		case bool:
+			num.Valid = true
+			if val {
+				num.Float64 = 1
+			}
+		case int64:
+			num = sql.NullFloat64{Float64: float64(val), Valid: true}
+		case float64:
+			num = sql.NullFloat64{Float64: val, Valid: true}
+		}
+		_, err = q.Exec("INSERT INTO "+s.table+" ("+s.owner+", name, str_value, num_value) VALUES (?, ?, ?, ?)", id, name, str, num)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load returns the attributes of each owner which has any, typed by the schema.
+func (s attrStore) load(q queryer, schema AttributeSchema, ids ...int64) (map[int64]Attributes, error) {
+	all := map[int64]Attributes{}
+	if len(ids) == 0 {
+		return all, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := q.Query("SELECT "+s.owner+", name, str_value, num_value FROM "+s.table+" WHERE "+s.owner+
+		" IN (?"+strings.Repeat(", ?", len(ids)-1)+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var name string
+		var str sql.NullString
+		var num sql.NullFloat64
+		if err = rows.Scan(&id, &name, &str, &num); err != nil {
+			return nil, err
+		}
+		var v interface{}
+		switch schema[name] {
+		case AttrString:
+			v = str.String
+		case AttrBool:
+			v = num.Float64 != 0
+		case AttrInt:
+			v = int64(num.Float64)
+		case AttrFloat:
+			v = num.Float64
+		default:
+			// No longer declared, return it as it was stored.
+			if str.Valid {
+				v = str.String
+			} else {
+				v = num.Float64
+			}
+		}
+		if all[id] == nil {
+			all[id] = Attributes{}
+		}
+		all[id][name] = v
+	}
+	return all, rows.Err()
+}
+
+// filterFields adds a field for each declared attribute to fields, e.g. "attributes.locale". ownerId is the
+// qualified id column of the owner in the list query.
+func (s attrStore) filterFields(fields FilterFields, schema AttributeSchema, ownerId string) FilterFields {
+	if len(schema) == 0 {
+		return fields
+	}
+	ff := FilterFields{}
+	for k, v := range fields {
+		ff[k] = v
+	}
+	for name, t := range schema {
+		if !attrName.MatchString(name) {
+			continue
+		}
+		col := "num_value"
+		if t == AttrString {
+			col = "str_value"
+		}
+		ff["attributes."+name] = "(SELECT a." + col + " FROM " + s.table + " a WHERE a." + s.owner + " = " + ownerId +
+			" AND a.name = '" + name + "')"
+	}
+	return ff
+}
+
+// withAttributes loads the attributes of the users when a schema is declared.
+func (us *Users) withAttributes(users ...*User) error {
+	if len(us.Attributes) == 0 || len(users) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(users))
+	for i, u := range users {
+		ids[i] = u.Id
+	}
+	all, err := userAttrs.load(us.db, us.Attributes, ids...)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		u.Attributes = all[u.Id]
+	}
+	return nil
+}
+
+// withAttributes loads the attributes of the orgs when a schema is declared, attributes in OrgOpts.Inherit which
+// an org hasn't set are taken from its nearest ancestor which has.
+func (us *Orgs) withAttributes(q queryer, orgs ...*Org) error {
+	if len(us.Attributes) == 0 || len(orgs) == 0 {
+		return nil
+	}
+	// Walk up the hierarchy a level at a time to find the parents of the orgs and their ancestors.
+	parents := map[int64]int64{}
+	ids := []int64{}
+	next := []int64{}
+	for _, o := range orgs {
+		if _, ok := parents[o.Id]; !ok {
+			parents[o.Id] = o.ParentId
+			ids = append(ids, o.Id)
+			if o.ParentId > 0 {
+				next = append(next, o.ParentId)
+			}
+		}
+	}
+	for i := 0; len(us.Inherit) > 0 && len(next) > 0 && i < maxOrgDepth; i++ {
+		args := []interface{}{}
+		for _, id := range next {
+			if _, ok := parents[id]; !ok {
+				args = append(args, id)
+			}
+		}
+		next = nil
+		if len(args) == 0 {
+			break
+		}
+		rows, err := q.Query("SELECT id, COALESCE(parent_id, 0) FROM orgs WHERE deleted = 0 AND id IN (?"+
+			strings.Repeat(", ?", len(args)-1)+")", args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id, parentId int64
+			if err = rows.Scan(&id, &parentId); err != nil {
+				rows.Close()
+				return err
+			}
+			parents[id] = parentId
+			ids = append(ids, id)
+			if parentId > 0 {
+				next = append(next, parentId)
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+	}
+	own, err := orgAttrs.load(q, us.Attributes, ids...)
+	if err != nil {
+		return err
+	}
+	for _, o := range orgs {
+		as := own[o.Id].clone()
+		for _, name := range us.Inherit {
+			if _, ok := as[name]; ok {
+				continue
+			}
+			for id, i := parents[o.Id], 0; id > 0 && i < maxOrgDepth; id, i = parents[id], i+1 {
+				if v, ok := own[id][name]; ok {
+					if as == nil {
+						as = Attributes{}
+					}
+					as[name] = v
+					break
+				}
+			}
+		}
+		o.Attributes = as
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package gus
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestUsers_Attributes(t *testing.T) {
+	ua := NewUsers(us.db, UserOpts{Attributes: AttributeSchema{"title": AttrString, "level": AttrInt, "score": AttrFloat, "vip": AttrBool}})
+	u, _, err := ua.SignUp(SignUpParams{Email: "attrs@mail.com", Password: "M0nk3yNutz5",
+		Attributes: Attributes{"title": "CTO", "level": float64(3), "vip": true}})
+	assert.Nil(t, err)
+	assert.Equal(t, Attributes{"title": "CTO", "level": int64(3), "vip": true}, u.Attributes)
+	got, err := ua.Get(u.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, u.Attributes, got.Attributes)
+
+	// Only the attributes given change.
+	var p UpdateUserParams
+	assert.Nil(t, json.Unmarshal([]byte(fmt.Sprintf(`{"id": %d, "attributes": {"level": 4, "score": 0.5, "vip": null}}`, u.Id)), &p))
+	assert.Nil(t, ua.Update(p))
+	got, err = ua.Get(u.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, Attributes{"title": "CTO", "level": int64(4), "score": 0.5}, got.Attributes)
+
+	_, _, err = ua.SignUp(SignUpParams{Email: "attrs2@mail.com", Password: "M0nk3yNutz5", Attributes: Attributes{"level": 1.5}})
+	assert.Equal(t, ErrInvalid("Attribute 'level' must be of type int."), err)
+	err = ua.Update(UpdateUserParams{Id: &u.Id, Attributes: Attributes{"shoe_size": 9}})
+	assert.Equal(t, ErrInvalid("Unknown attribute 'shoe_size'."), err)
+	_, _, err = us.SignUp(SignUpParams{Email: "attrs3@mail.com", Password: "M0nk3yNutz5", Attributes: Attributes{"title": "CEO"}})
+	assert.IsType(t, &ValidationError{}, err)
+
+	_, _, err = ua.SignUp(SignUpParams{Email: "attrs4@mail.com", Password: "M0nk3yNutz5", Attributes: Attributes{"title": "CFO", "level": 4}})
+	assert.Nil(t, err)
+	res, err := ua.List(ListUsersParams{UserFilters: UserFilters{Where: And(Eq("attributes.level", 4), Prefix("attributes.title", "CT"))}})
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(res.Items)) {
+		assert.Equal(t, u.Id, res.Items[0].Id)
+		assert.Equal(t, "CTO", res.Items[0].Attributes["title"])
+	}
+	res, err = ua.List(ListUsersParams{UserFilters: UserFilters{Where: And(Prefix("email", "attrs"), IsNull("attributes.score"))}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), res.Total)
+	_, err = us.List(ListUsersParams{UserFilters: UserFilters{Where: Eq("attributes.level", 4)}})
+	assert.IsType(t, &ValidationError{}, err)
+}
+
+func TestOrgs_Attributes(t *testing.T) {
+	ov := NewOrgs(orgsv.db)
+	ov.OrgOpts = OrgOpts{Attributes: AttributeSchema{"plan": AttrString, "locale": AttrString, "seats": AttrInt, "beta": AttrBool},
+		Inherit: []string{"locale", "beta"}}
+	parent, err := ov.Create(CreateOrgParams{Name: "Attr Parent", Attributes: Attributes{"plan": "pro", "locale": "en-NZ", "seats": 10}})
+	assert.Nil(t, err)
+	assert.Equal(t, Attributes{"plan": "pro", "locale": "en-NZ", "seats": int64(10)}, parent.Attributes)
+	team, err := ov.Create(CreateOrgParams{Name: "Attr Team", ParentId: parent.Id})
+	assert.Nil(t, err)
+	sub, err := ov.Create(CreateOrgParams{Name: "Attr Sub", ParentId: team.Id})
+	assert.Nil(t, err)
+
+	// Only inherited attributes pass down, from the nearest ancestor which has them.
+	got, err := ov.Get(sub.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, Attributes{"locale": "en-NZ"}, got.Attributes)
+	assert.Nil(t, ov.SetAttribute(team.Id, "locale", "mi-NZ"))
+	assert.Nil(t, ov.SetAttribute(parent.Id, "beta", true))
+	got, err = ov.Get(sub.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, Attributes{"locale": "mi-NZ", "beta": true}, got.Attributes)
+	locale, ok := got.Attributes.String("locale")
+	assert.True(t, ok)
+	assert.Equal(t, "mi-NZ", locale)
+	beta, ok := got.Attributes.Bool("beta")
+	assert.True(t, ok && beta)
+	_, ok = got.Attributes.Int("seats")
+	assert.False(t, ok)
+
+	// Own values override inherited ones until removed.
+	assert.Nil(t, ov.Update(UpdateOrgParams{Id: &sub.Id, Attributes: Attributes{"beta": false, "seats": 2}}))
+	got, err = ov.Get(sub.Id)
+	assert.Nil(t, err)
+	seats, _ := got.Attributes.Int("seats")
+	assert.Equal(t, int64(2), seats)
+	assert.Equal(t, false, got.Attributes["beta"])
+	assert.Nil(t, ov.SetAttribute(sub.Id, "beta", nil))
+	got, err = ov.Get(sub.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, true, got.Attributes["beta"])
+
+	res, err := ov.List(ListOrgsParams{OrgFilters: OrgFilters{Under: parent.Id, Where: Eq("attributes.plan", "pro")}})
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(res.Items)) {
+		assert.Equal(t, Attributes{"plan": "pro", "locale": "en-NZ", "seats": int64(10), "beta": true}, res.Items[0].Attributes)
+	}
+	res, err = ov.List(ListOrgsParams{OrgFilters: OrgFilters{Under: parent.Id}, ListArgs: ListArgs{OrderBy: "id", Direction: DirectionAsc}})
+	assert.Nil(t, err)
+	if assert.Equal(t, 3, len(res.Items)) {
+		assert.Equal(t, "mi-NZ", res.Items[2].Attributes["locale"])
+	}
+
+	assert.Equal(t, ErrInvalid("Attribute 'seats' must be of type int."), ov.SetAttribute(sub.Id, "seats", "many"))
+	_, err = ov.Create(CreateOrgParams{Name: "Attr Bad", Attributes: Attributes{"colour": "red"}})
+	assert.Equal(t, ErrInvalid("Unknown attribute 'colour'."), err)
+}
@@ -0,0 +1,289 @@
+package gus
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	AuditSignUp         = "user.sign_up"
+	AuditSignIn         = "user.sign_in"
+	AuditSignInFailed   = "user.sign_in_failed"
+	AuditSignInLimited  = "user.sign_in_rate_limited"
+	AuditUserUpdate     = "user.update"
+	AuditAssignRole     = "user.assign_role"
+	AuditUserSuspend    = "user.suspend"
+	AuditUserRestore    = "user.restore"
+	AuditUserDelete     = "user.delete"
+	AuditUserUnDelete   = "user.undelete"
+	AuditUserErase      = "user.erase"
+	AuditPasswordChange = "user.password_change"
+	AuditPasswordReset  = "user.password_reset"
+	AuditEmailRequest   = "user.request_email_change"
+	AuditEmailChange    = "user.change_email"
+	AuditOrgCreate      = "org.create"
+	AuditOrgUpdate      = "org.update"
+	AuditOrgSuspend     = "org.suspend"
+	AuditOrgRestore     = "org.restore"
+	AuditOrgDelete      = "org.delete"
+	AuditOrgUnDelete    = "org.undelete"
+	AuditMemberAdd      = "org.add_member"
+	AuditMemberRemove   = "org.remove_member"
+	AuditMemberSuspend  = "org.suspend_member"
+	AuditMemberRestore  = "org.restore_member"
+	AuditMemberInvite   = "org.invite_member"
+	AuditInviteAccept   = "org.accept_invitation"
+	AuditInviteDecline  = "org.decline_invitation"
+	AuditInviteCreate   = "org.create_invite"
+	AuditInviteRevoke   = "org.revoke_invite"
+	AuditRoleCreate     = "org.create_role"
+	AuditRolePerms      = "org.set_role_permissions"
+	AuditRoleDelete     = "org.delete_role"
+
+	TargetUser = "user"
+	TargetOrg  = "org"
+)
+
+// Change is the value of a field before and after an update.
+type Change struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+type AuditEvent struct {
+	Id         int64             `json:"id"`
+	Action     string            `json:"action"`
+	ActorId    int64             `json:"actor_id"` // 0 when unknown e.g. a failed sign in.
+	TargetType string            `json:"target_type"`
+	TargetId   int64             `json:"target_id"`
+	Ip         string            `json:"ip"`
+	Changes    map[string]Change `json:"changes,omitempty"`
+	Created    int64             `json:"created"`
+}
+
+// Auditor records audit events, errors are logged and never fail the audited operation. AuditLog is the
+// exception, it records changes in their transaction so a change and its audit event are committed together.
+type Auditor interface {
+	Record(e AuditEvent) error
+}
+
+// txAuditor is an Auditor which can record an event in the transaction of the audited change.
+type txAuditor interface {
+	recordTx(tx *sql.Tx, e AuditEvent) error
+}
+
+// AuditContext identifies who is performing operations and from where, see Users.By and Orgs.By.
+type AuditContext struct {
+	ActorId int64
+	Ip      string
+}
+
+func (ac AuditContext) record(a Auditor, action string, targetType string, targetId int64, changes map[string]Change) {
+	if a == nil {
+		return
+	}
+	if err := a.Record(ac.event(action, targetType, targetId, changes)); err != nil {
+		LogErr(err)
+	}
+}
+
+func (ac AuditContext) event(action string, targetType string, targetId int64, changes map[string]Change) AuditEvent {
+	return AuditEvent{Action: action, ActorId: ac.ActorId, TargetType: targetType, TargetId: targetId, Ip: ac.Ip,
+		Changes: changes, Created: Milliseconds(time.Now())}
+}
+
+// self returns the context with the actor set to id when no actor was given, for self service operations.
+func (ac AuditContext) self(id int64) AuditContext {
+	if ac.ActorId == 0 {
+		ac.ActorId = id
+	}
+	return ac
+}
+
+// By returns a copy of Users which records actorId and ip against audit events.
+func (us *Users) By(actorId int64, ip string) *Users {
+	c := *us
+	c.audit = AuditContext{ActorId: actorId, Ip: ip}
+	return &c
+}
+
+// By returns a copy of Orgs which records actorId and ip against audit events.
+func (us *Orgs) By(actorId int64, ip string) *Orgs {
+	c := *us
+	c.audit = AuditContext{ActorId: actorId, Ip: ip}
+	return &c
+}
+
+// By returns a copy of Invites which records actorId and ip against audit events.
+func (in *Invites) By(actorId int64, ip string) *Invites {
+	c := *in
+	c.audit = AuditContext{ActorId: actorId, Ip: ip}
+	return &c
+}
+
+// By returns a copy of Roles which records actorId and ip against audit events.
+func (ro *Roles) By(actorId int64, ip string) *Roles {
+	c := *ro
+	c.audit = AuditContext{ActorId: actorId, Ip: ip}
+	return &c
+}
+
+// actedBy returns a copy of Users which records the actor against audit events, the ip is kept.
+func (us *Users) actedBy(actor *Claims) *Users {
+	if actor == nil {
+		return us
+	}
+	return us.By(actor.UserId, us.audit.Ip)
+}
+
+func (us *Orgs) actedBy(actor *Claims) *Orgs {
+	if actor == nil {
+		return us
+	}
+	return us.By(actor.UserId, us.audit.Ip)
+}
+
+func roleChange(from Role, to Role) map[string]Change {
+	return map[string]Change{"role": {From: from, To: to}}
+}
+
+func NewAuditLog(db *sql.DB) *AuditLog {
+	return &AuditLog{db: db}
+}
+
+// AuditLog stores audit events in the audit_events table.
+type AuditLog struct {
+	db *sql.DB
+}
+
+func (al *AuditLog) Record(e AuditEvent) error {
+	return insertAuditEvent(al.db, e)
+}
+
+func (al *AuditLog) recordTx(tx *sql.Tx, e AuditEvent) error {
+	return insertAuditEvent(tx, e)
+}
+
+func insertAuditEvent(q queryer, e AuditEvent) error {
+	var data []byte
+	if len(e.Changes) > 0 {
+		var err error
+		data, err = json.Marshal(e.Changes)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := q.Exec("INSERT INTO audit_events (action, actor_id, target_type, target_id, ip, changes, created) values (?, ?, ?, ?, ?, ?, ?)",
+		e.Action, e.ActorId, e.TargetType, e.TargetId, e.Ip, string(data), e.Created)
+	return err
+}
+
+type ListAuditParams struct {
+	ListArgs
+	CustomValidator `json:"-"`
+	AuditFilters
+}
+
+type AuditFilters struct {
+	Action     string `schema:"action"`
+	ActorId    int64  `schema:"actor_id"`
+	TargetType string `schema:"target_type"`
+	TargetId   int64  `schema:"target_id"`
+	Ip         string `schema:"ip"`
+	Since      int64  `schema:"since"` // Milliseconds, inclusive.
+	Until      int64  `schema:"until"` // Milliseconds, exclusive.
+}
+
+func (va *ListAuditParams) Validate() error {
+	if va.CustomValidator != nil {
+		return va.CustomValidator()
+	}
+	return nil
+}
+
+type AuditListResponse struct {
+	ListArgs
+	Total int64         `json:"total"`
+	Items []*AuditEvent `json:"items"`
+}
+
+const auditCols = "id, action, actor_id, target_type, target_id, ip, changes, created"
+
+func scanAuditEvent(row scanner) (*AuditEvent, error) {
+	e := &AuditEvent{}
+	var ip, changes sql.NullString
+	err := row.Scan(&e.Id, &e.Action, &e.ActorId, &e.TargetType, &e.TargetId, &ip, &changes, &e.Created)
+	if err != nil {
+		return nil, err
+	}
+	e.Ip = ip.String
+	if changes.String != "" {
+		if err = json.Unmarshal([]byte(changes.String), &e.Changes); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// List returns audit events, newest first unless otherwise ordered.
+func (al *AuditLog) List(p ListAuditParams) (*AuditListResponse, error) {
+	q := "SELECT " + auditCols + " FROM audit_events WHERE 1"
+	countq := "SELECT count(id) FROM audit_events WHERE 1"
+
+	args := []interface{}{}
+	if p.Action != "" {
+		q, countq, args = addClause(q, countq, " AND action = ?", args, p.Action)
+	}
+	if p.ActorId > 0 {
+		q, countq, args = addClause(q, countq, " AND actor_id = ?", args, p.ActorId)
+	}
+	if p.TargetType != "" {
+		q, countq, args = addClause(q, countq, " AND target_type = ?", args, p.TargetType)
+	}
+	if p.TargetId > 0 {
+		q, countq, args = addClause(q, countq, " AND target_id = ?", args, p.TargetId)
+	}
+	if p.Ip != "" {
+		q, countq, args = addClause(q, countq, " AND ip = ?", args, p.Ip)
+	}
+	if p.Since > 0 {
+		q, countq, args = addClause(q, countq, " AND created >= ?", args, p.Since)
+	}
+	if p.Until > 0 {
+		q, countq, args = addClause(q, countq, " AND created < ?", args, p.Until)
+	}
+	if p.OrderBy == "" {
+		p.OrderBy = "id"
+	}
+	rows, err := GetRows(al.db, q, AuditSortFields, &p.ListArgs, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var total int64
+	err = al.db.QueryRow(countq, args...).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+	items := []*AuditEvent{}
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return &AuditListResponse{
+		Total: total,
+		Items: items,
+		ListArgs: ListArgs{
+			Size:      p.Size,
+			Page:      p.Page,
+			Direction: p.Direction,
+			OrderBy:   p.OrderBy,
+		}}, nil
+}
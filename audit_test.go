@@ -0,0 +1,107 @@
+package gus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	password := "M0nk3yNutz5"
+	al := NewAuditLog(us.db)
+	ua := us.By(0, "10.0.0.1")
+	ua.Auditor = al
+	oa := orgsv.By(0, "10.0.0.1")
+	oa.Auditor = al
+
+	o, err := oa.Create(CreateOrgParams{Name: "Audit Co."})
+	assert.Nil(t, err)
+	u, _, err := ua.SignUp(SignUpParams{Email: "audit@mail.com", Password: password, OrgId: o.Id})
+	assert.Nil(t, err)
+	_, err = ua.SignIn(SignInParams{Email: u.Email, Password: "wrong"})
+	assert.Equal(t, ErrNotAuth, err)
+	_, err = ua.SignIn(SignInParams{Email: u.Email, Password: password})
+	assert.Nil(t, err)
+	name := "Audrey"
+	assert.Nil(t, ua.By(42, "10.0.0.2").Update(UpdateUserParams{Id: &u.Id, FirstName: &name}))
+	assert.Nil(t, ua.Suspend(u.Id))
+	assert.Nil(t, ua.Restore(u.Id))
+	// Failed operations aren't recorded
+	assert.Equal(t, ErrNotFound, ua.Suspend(-1))
+
+	res, err := al.List(ListAuditParams{AuditFilters: AuditFilters{TargetType: TargetUser, TargetId: u.Id}, ListArgs: ListArgs{Direction: DirectionAsc}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), res.Total)
+	actions := []string{}
+	for _, e := range res.Items {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{AuditSignUp, AuditSignInFailed, AuditSignIn, AuditUserUpdate, AuditUserSuspend, AuditUserRestore}, actions)
+	assert.Equal(t, u.Id, res.Items[0].ActorId)
+	assert.Equal(t, "10.0.0.1", res.Items[0].Ip)
+	assert.Equal(t, int64(0), res.Items[1].ActorId)
+	update := res.Items[3]
+	assert.Equal(t, int64(42), update.ActorId)
+	assert.Equal(t, "10.0.0.2", update.Ip)
+	assert.Equal(t, 1, len(update.Changes))
+	assert.Equal(t, Change{From: "", To: "Audrey"}, update.Changes["first_name"])
+
+	res, err = al.List(ListAuditParams{AuditFilters: AuditFilters{ActorId: 42}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), res.Total)
+	res, err = al.List(ListAuditParams{AuditFilters: AuditFilters{TargetType: TargetOrg, TargetId: o.Id, Action: AuditOrgCreate}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), res.Total)
+}
+
+func TestAuditLog_Mutations(t *testing.T) {
+	password := "M0nk3yNutz5"
+	al := NewAuditLog(us.db)
+	ua := us.By(0, "10.0.0.4")
+	ua.Auditor = al
+	oa := orgsv.By(0, "10.0.0.4")
+	oa.Auditor = al
+	ia := invs.By(7, "10.0.0.4")
+	ia.Auditor = al
+	ra := NewRoles(us.db).By(7, "10.0.0.4")
+	ra.Auditor = al
+	actions := func(targetType string, targetId int64) []string {
+		res, err := al.List(ListAuditParams{AuditFilters: AuditFilters{TargetType: targetType, TargetId: targetId}, ListArgs: ListArgs{Direction: DirectionAsc}})
+		assert.Nil(t, err)
+		actions := []string{}
+		for _, e := range res.Items {
+			actions = append(actions, e.Action)
+		}
+		return actions
+	}
+
+	o, err := oa.Create(CreateOrgParams{Name: "Audited Mutations Co."})
+	assert.Nil(t, err)
+	u, _, err := ua.SignUp(SignUpParams{Email: "audited-mutations@mail.com", Password: password, OrgId: o.Id, Role: RoleMember})
+	assert.Nil(t, err)
+	token, err := ua.RequestEmailChange(u.Id, "audited-mutations-2@mail.com")
+	assert.Nil(t, err)
+	_, err = ua.ConfirmEmailChange(token)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{AuditSignUp, AuditEmailRequest, AuditEmailChange}, actions(TargetUser, u.Id))
+
+	assert.Nil(t, oa.SuspendMember(o.Id, u.Id))
+	assert.Nil(t, oa.RestoreMember(o.Id, u.Id))
+	i, err := ia.Create(CreateInviteParams{OrgId: o.Id, Role: RoleMember})
+	assert.Nil(t, err)
+	assert.Nil(t, ia.Revoke(i.Id))
+	r, err := ra.Create(CreateRoleParams{Name: "Audited", Level: 50, OrgId: o.Id})
+	assert.Nil(t, err)
+	assert.Nil(t, ra.SetPermissions(r.Id, []Permission{PermOrgRead}))
+	assert.Nil(t, ra.Delete(r.Id))
+	assert.Equal(t, []string{AuditOrgCreate, AuditMemberSuspend, AuditMemberRestore, AuditInviteCreate, AuditInviteRevoke,
+		AuditRoleCreate, AuditRolePerms, AuditRoleDelete}, actions(TargetOrg, o.Id))
+
+	// Rate limited sign-ins
+	for i := 0; i < 5; i++ {
+		ua.isLocked("audited-mutations-2@mail.com")
+	}
+	_, err = ua.SignIn(SignInParams{Email: "audited-mutations-2@mail.com", Password: password})
+	assert.IsType(t, &RateLimitExceededError{}, err)
+	got := actions(TargetUser, u.Id)
+	assert.Equal(t, AuditSignInLimited, got[len(got)-1])
+}
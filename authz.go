@@ -0,0 +1,372 @@
+package gus
+
+import (
+	"database/sql"
+)
+
+var (
+	ErrRoleTooHigh    = ErrInvalid("You can't grant a role higher than your own.")
+	ErrMembershipOnly = ErrInvalid("That user belongs to orgs you don't manage, only their membership of your org can be changed.")
+)
+
+// actorClaims re-reads the actor's claims from their membership of their active org, the role and suspensions in
+// the given claims may be stale. Actors who are suspended or no longer members aren't authorized.
+func actorClaims(q queryer, actor *Claims) (*Claims, error) {
+	if actor == nil {
+		return nil, ErrNotAuth
+	}
+	var id int64
+	err := lookupUsers(activeUsers).byId(actor.UserId).row(q, "u.id", "users u").Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotAuth
+	}
+	if err != nil {
+		return nil, err
+	}
+	if actor.OrgId == 0 {
+		return &Claims{UserId: actor.UserId}, nil
+	}
+	c, err := memberClaims(q, actor.UserId, actor.OrgId)
+	if err == ErrNotFound {
+		return nil, ErrNotAuth
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.OrgSuspended || c.MembershipSuspended {
+		return nil, ErrNotAuth
+	}
+	return c, nil
+}
+
+// authorize returns the actor's current claims when their role in their active org grants perm and the target user
+// is a member of the same org with a role no higher than the actor's. Users of other orgs are reported as not found.
+func authorize(q queryer, actor *Claims, targetId int64, perm Permission) (*Claims, error) {
+	c, err := actorClaims(q, actor)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := roleCan(q, c.Role, perm)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrForbidden
+	}
+	var targetRole Role
+	err = CheckNotFound(q.QueryRow("SELECT role FROM memberships WHERE user_id = ? AND org_id = ?", targetId, c.OrgId).Scan(&targetRole))
+	if err != nil {
+		return nil, err
+	}
+	return c, checkLevel(q, c.Role, targetRole, ErrForbidden)
+}
+
+// authorizeAccount returns nil when the actor may change the target's account rather than just their membership of
+// the actor's org. The target's default org must be the actor's and the actor's role in every org the target
+// belongs to must be no lower than the target's, otherwise it returns ErrMembershipOnly.
+func authorizeAccount(q queryer, actor *Claims, targetId int64, perm Permission) error {
+	if _, err := authorize(q, actor, targetId, perm); err != nil {
+		return err
+	}
+	var defaultOrgId int64
+	err := CheckNotFound(lookupUsers(allUsers).byId(targetId).row(q, "u.org_id", "users u").Scan(&defaultOrgId))
+	if err != nil {
+		return err
+	}
+	if defaultOrgId != actor.OrgId {
+		return ErrMembershipOnly
+	}
+	rows, err := q.Query("SELECT m.role, a.role FROM memberships m LEFT JOIN memberships a ON a.org_id = m.org_id "+
+		"AND a.user_id = ? AND a.suspended = 0 WHERE m.user_id = ?", actor.UserId, targetId)
+	if err != nil {
+		return err
+	}
+	type roles struct {
+		target Role
+		actor  sql.NullInt64
+	}
+	var memberships []roles
+	for rows.Next() {
+		var r roles
+		if err = rows.Scan(&r.target, &r.actor); err != nil {
+			rows.Close()
+			return err
+		}
+		memberships = append(memberships, r)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, r := range memberships {
+		if !r.actor.Valid {
+			return ErrMembershipOnly
+		}
+		if err = checkLevel(q, Role(r.actor.Int64), r.target, ErrMembershipOnly); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkLevel returns errTooHigh if role has a higher level than the actor's role.
+func checkLevel(q queryer, actorRole Role, role Role, errTooHigh error) error {
+	actorLevel, err := roleLevel(q, actorRole)
+	if err != nil {
+		return err
+	}
+	level, err := roleLevel(q, role)
+	if err != nil {
+		return err
+	}
+	if level > actorLevel {
+		return errTooHigh
+	}
+	return nil
+}
+
+func roleCan(q queryer, role Role, perm Permission) (bool, error) {
+	var count int64
+	err := q.QueryRow("SELECT count(p.role_id) FROM roles r JOIN role_permissions p ON p.role_id = r.id "+
+		"WHERE r.id = ? AND r.deleted = 0 AND p.permission = ?", role, perm).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// roleLevel returns the level of the role, RoleNone and deleted roles have level 0.
+func roleLevel(q queryer, role Role) (int64, error) {
+	var level int64
+	err := q.QueryRow("SELECT level FROM roles WHERE id = ? AND deleted = 0", role).Scan(&level)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return level, err
+}
+
+// guarded returns a copy of Users acting for the actor which runs check first in the transaction of each operation,
+// so the actor can't lose their authority between the check and the change.
+func (us *Users) guarded(actor *Claims, check func(q queryer) error) *Users {
+	c := *us.actedBy(actor)
+	c.guard = check
+	return &c
+}
+
+// accountAs returns a copy of Users which changes the account of the user on behalf of the actor, or
+// ErrMembershipOnly when the actor may only change the user's membership of their org.
+func (us *Users) accountAs(actor *Claims, id int64) (*Users, error) {
+	check := func(q queryer) error {
+		return authorizeAccount(q, actor, id, PermUsersWrite)
+	}
+	if err := check(us.db); err != nil {
+		return nil, err
+	}
+	return us.guarded(actor, check), nil
+}
+
+// DeleteAs deletes the user on behalf of the actor, a user who belongs to orgs the actor doesn't manage is only
+// removed from the actor's org.
+func (us *Users) DeleteAs(actor *Claims, id int64) error {
+	if actor != nil && actor.UserId == id {
+		return ErrCantDeleteSelf
+	}
+	c, err := us.accountAs(actor, id)
+	if err == ErrMembershipOnly {
+		e := &Event{Kind: MemberRemoved, OrgId: actor.OrgId, UserId: id, Changes: map[string]Change{"user_id": {From: id}}}
+		return us.memberAs(actor, id).emit(e, AuditMemberRemove, func(tx *sql.Tx) error {
+			return removeMember(tx, actor.OrgId, id)
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return c.Delete(id)
+}
+
+func (us *Users) UnDeleteAs(actor *Claims, id int64) error {
+	c, err := us.accountAs(actor, id)
+	if err != nil {
+		return err
+	}
+	return c.UnDelete(id)
+}
+
+// SuspendAs suspends the user on behalf of the actor, a user who belongs to orgs the actor doesn't manage is only
+// suspended from the actor's org.
+func (us *Users) SuspendAs(actor *Claims, id int64) error {
+	if actor != nil && actor.UserId == id {
+		return ErrCantSuspendSelf
+	}
+	return us.setSuspendedAs(actor, id, true)
+}
+
+func (us *Users) RestoreAs(actor *Claims, id int64) error {
+	return us.setSuspendedAs(actor, id, false)
+}
+
+func (us *Users) setSuspendedAs(actor *Claims, id int64, suspended bool) error {
+	c, err := us.accountAs(actor, id)
+	if err == ErrMembershipOnly {
+		e, action := &Event{Kind: MemberSuspended, OrgId: actor.OrgId, UserId: id}, AuditMemberSuspend
+		if !suspended {
+			e.Kind, action = MemberRestored, AuditMemberRestore
+		}
+		return us.memberAs(actor, id).emit(e, action, func(tx *sql.Tx) error {
+			return setMemberSuspended(tx, actor.OrgId, id, suspended)
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if suspended {
+		return c.Suspend(id)
+	}
+	return c.Restore(id)
+}
+
+// memberAs returns a copy of Users which changes the user's membership of the actor's org on behalf of the actor.
+func (us *Users) memberAs(actor *Claims, id int64) *Users {
+	return us.guarded(actor, func(q queryer) error {
+		_, err := authorize(q, actor, id, PermUsersWrite)
+		return err
+	})
+}
+
+// UpdateAs updates the user on behalf of the actor, users can update themselves unless they or their membership of
+// their active org are suspended but other users' accounts need PermUsersWrite, see authorizeAccount, as does
+// moving a user to another org.
+func (us *Users) UpdateAs(actor *Claims, p UpdateUserParams) error {
+	if p.Id == nil {
+		return ErrNotFound
+	}
+	id, orgId := *p.Id, p.OrgId
+	check := func(q queryer) error {
+		if actor != nil && actor.UserId == id && orgId == nil {
+			_, err := actorClaims(q, actor)
+			return err
+		}
+		if err := authorizeAccount(q, actor, id, PermUsersWrite); err != nil {
+			return err
+		}
+		if orgId == nil {
+			return nil
+		}
+		// Users can only be moved within the actor's org and its descendants.
+		ids, err := subtreeIds(q, actor.OrgId)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if id == *orgId {
+				return nil
+			}
+		}
+		return ErrForbidden
+	}
+	if err := check(us.db); err != nil {
+		return err
+	}
+	return us.guarded(actor, check).Update(p)
+}
+
+// AssignRoleAs changes the user's role in the actor's active org, the role can't be higher than the actor's.
+func (us *Users) AssignRoleAs(actor *Claims, p AssignRoleParams) error {
+	if p.Id == nil {
+		return ErrNotFound
+	}
+	role := RoleNone
+	if p.Role != nil {
+		role = *p.Role
+	}
+	if actor == nil {
+		return ErrNotAuth
+	}
+	e := &Event{Kind: RoleAssigned, UserId: *p.Id, OrgId: actor.OrgId, Changes: map[string]Change{"role": {To: role}, "org_id": {To: actor.OrgId}}}
+	return us.actedBy(actor).emit(e, AuditAssignRole, func(tx *sql.Tx) error {
+		c, err := authorize(tx, actor, *p.Id, PermUsersWrite)
+		if err != nil {
+			return err
+		}
+		var passive bool
+		err = CheckNotFound(lookupUsers(liveUsers).byId(*p.Id).row(tx, "COALESCE(u.passive, 0)", "users u").Scan(&passive))
+		if err != nil {
+			return err
+		}
+		if passive {
+			return ErrUserPassive
+		}
+		err = checkLevel(tx, c.Role, role, ErrRoleTooHigh)
+		if err != nil {
+			return err
+		}
+		return assignMemberRole(tx, actor.OrgId, *p.Id, role)
+	})
+}
+
+// authorizeOrg returns nil when the actor's current role in the org grants perm.
+func authorizeOrg(q queryer, actor *Claims, orgId int64, perm Permission) error {
+	c, err := actorClaims(q, actor)
+	if err != nil {
+		return err
+	}
+	if c.OrgId != orgId {
+		return ErrForbidden
+	}
+	ok, err := roleCan(q, c.Role, perm)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// guarded returns a copy of Orgs acting for the actor which runs check first in the transaction of each operation.
+func (us *Orgs) guarded(actor *Claims, check func(q queryer) error) *Orgs {
+	c := *us.actedBy(actor)
+	c.guard = check
+	return &c
+}
+
+// UpdateAs updates the org on behalf of the actor, who must be acting in that org. The actor can't move the org,
+// the new parent would be outside their subtree or a descendant of the org, so ParentId must be the current parent.
+func (us *Orgs) UpdateAs(actor *Claims, p UpdateOrgParams) error {
+	if p.Id == nil {
+		return ErrNotFound
+	}
+	id, parentId := *p.Id, p.ParentId
+	check := func(q queryer) error {
+		if err := authorizeOrg(q, actor, id, PermOrgWrite); err != nil {
+			return err
+		}
+		if parentId == nil {
+			return nil
+		}
+		var current int64
+		err := CheckNotFound(q.QueryRow("SELECT COALESCE(parent_id, 0) FROM orgs WHERE id = ?", id).Scan(&current))
+		if err != nil {
+			return err
+		}
+		if current != *parentId {
+			return ErrForbidden
+		}
+		return nil
+	}
+	if err := check(us.db); err != nil {
+		return err
+	}
+	return us.guarded(actor, check).Update(p)
+}
+
+// DeleteAs deletes the org on behalf of the actor, who must be acting in that org.
+func (us *Orgs) DeleteAs(actor *Claims, id int64) error {
+	check := func(q queryer) error {
+		return authorizeOrg(q, actor, id, PermOrgDelete)
+	}
+	if err := check(us.db); err != nil {
+		return err
+	}
+	return us.guarded(actor, check).Delete(id)
+}
@@ -0,0 +1,165 @@
+package gus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestUsers_Actors(t *testing.T) {
+	o, err := orgsv.Create(CreateOrgParams{Name: "Actors Inc."})
+	assert.Nil(t, err)
+	other, err := orgsv.Create(CreateOrgParams{Name: "Other Actors Inc."})
+	assert.Nil(t, err)
+	password := "M0nk3yNutz5"
+	signUp := func(email string, orgId int64, role Role) *UserWithClaims {
+		_, _, err := us.SignUp(SignUpParams{Email: email, Password: password, OrgId: orgId, Role: role})
+		assert.Nil(t, err)
+		uc, err := us.SignIn(SignInParams{Email: email, Password: password})
+		assert.Nil(t, err)
+		return uc
+	}
+	owner := signUp("actor-owner@mail.com", o.Id, RoleOwner)
+	admin := signUp("actor-admin@mail.com", o.Id, RoleAdmin)
+	member := signUp("actor-member@mail.com", o.Id, RoleMember)
+	outsider := signUp("actor-outsider@mail.com", other.Id, RoleOwner)
+
+	// Self
+	assert.Equal(t, ErrCantDeleteSelf, us.DeleteAs(admin.Claims, admin.User.Id))
+	assert.Equal(t, ErrCantSuspendSelf, us.SuspendAs(admin.Claims, admin.User.Id))
+	name := "Me"
+	assert.Nil(t, us.UpdateAs(member.Claims, UpdateUserParams{Id: &member.User.Id, FirstName: &name}))
+
+	// Permissions and role levels
+	assert.Equal(t, ErrForbidden, us.SuspendAs(member.Claims, admin.User.Id))
+	assert.Equal(t, ErrForbidden, us.SuspendAs(admin.Claims, owner.User.Id))
+	assert.Nil(t, us.SuspendAs(admin.Claims, member.User.Id))
+	assert.Nil(t, us.RestoreAs(admin.Claims, member.User.Id))
+	role := RoleOwner
+	assert.Equal(t, ErrRoleTooHigh, us.AssignRoleAs(admin.Claims, AssignRoleParams{Id: &member.User.Id, Role: &role}))
+	role = RoleAdmin
+	assert.Nil(t, us.AssignRoleAs(admin.Claims, AssignRoleParams{Id: &member.User.Id, Role: &role}))
+	ok, err := us.HasPermission(member.User.Id, PermUsersWrite)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// Other orgs
+	assert.Equal(t, ErrNotFound, us.DeleteAs(outsider.Claims, member.User.Id))
+	assert.Equal(t, ErrNotFound, us.UpdateAs(outsider.Claims, UpdateUserParams{Id: &member.User.Id, FirstName: &name}))
+	assert.Equal(t, ErrForbidden, orgsv.DeleteAs(outsider.Claims, o.Id))
+	assert.Equal(t, ErrForbidden, orgsv.DeleteAs(admin.Claims, o.Id))
+
+	assert.Nil(t, us.DeleteAs(owner.Claims, member.User.Id))
+	assert.Nil(t, us.UnDeleteAs(owner.Claims, member.User.Id))
+	assert.Nil(t, orgsv.DeleteAs(owner.Claims, o.Id))
+}
+
+func TestUsers_ActorsAcrossOrgs(t *testing.T) {
+	a, err := orgsv.Create(CreateOrgParams{Name: "Shared A Inc."})
+	assert.Nil(t, err)
+	b, err := orgsv.Create(CreateOrgParams{Name: "Shared B Inc."})
+	assert.Nil(t, err)
+	password := "M0nk3yNutz5"
+	signUp := func(email string, orgId int64, role Role) *UserWithClaims {
+		_, _, err := us.SignUp(SignUpParams{Email: email, Password: password, OrgId: orgId, Role: role})
+		assert.Nil(t, err)
+		uc, err := us.SignIn(SignInParams{Email: email, Password: password})
+		assert.Nil(t, err)
+		return uc
+	}
+	admin := signUp("shared-admin@mail.com", a.Id, RoleAdmin)
+	u := signUp("shared-member@mail.com", a.Id, RoleMember)
+	assert.Nil(t, orgsv.AddMember(b.Id, u.User.Id, RoleOwner))
+
+	// The user owns another org so the admin can only change their membership of a.
+	email := "taken-over@mail.com"
+	assert.Equal(t, ErrMembershipOnly, us.UpdateAs(admin.Claims, UpdateUserParams{Id: &u.User.Id, Email: &email}))
+	assert.Nil(t, us.SuspendAs(admin.Claims, u.User.Id))
+	got, err := us.Get(u.User.Id)
+	assert.Nil(t, err)
+	assert.False(t, got.Suspended)
+	ms, err := us.Memberships(u.User.Id)
+	assert.Nil(t, err)
+	for _, m := range ms {
+		assert.Equal(t, m.OrgId == a.Id, m.Suspended)
+	}
+	assert.Nil(t, us.RestoreAs(admin.Claims, u.User.Id))
+	assert.Nil(t, us.DeleteAs(admin.Claims, u.User.Id))
+	got, err = us.Get(u.User.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, b.Id, got.OrgId)
+
+	// Passive users can't be given roles.
+	passive, _, err := us.SignUp(SignUpParams{Email: "shared-passive@mail.com", OrgId: a.Id, Role: RoleMember, Passive: true})
+	assert.Nil(t, err)
+	role := RoleAdmin
+	assert.Equal(t, ErrUserPassive, us.AssignRoleAs(admin.Claims, AssignRoleParams{Id: &passive.Id, Role: &role}))
+}
+
+func TestUsers_ActorsStaleClaims(t *testing.T) {
+	o, err := orgsv.Create(CreateOrgParams{Name: "Stale Claims Inc."})
+	assert.Nil(t, err)
+	password := "M0nk3yNutz5"
+	signUp := func(email string, role Role) *UserWithClaims {
+		_, _, err := us.SignUp(SignUpParams{Email: email, Password: password, OrgId: o.Id, Role: role})
+		assert.Nil(t, err)
+		uc, err := us.SignIn(SignInParams{Email: email, Password: password})
+		assert.Nil(t, err)
+		return uc
+	}
+	owner := signUp("stale-owner@mail.com", RoleOwner)
+	admin := signUp("stale-admin@mail.com", RoleAdmin)
+	member := signUp("stale-member@mail.com", RoleMember)
+	name := "Stale"
+
+	// A demoted admin's claims still say admin.
+	role := RoleMember
+	assert.Nil(t, us.AssignRoleAs(owner.Claims, AssignRoleParams{Id: &admin.User.Id, Role: &role}))
+	assert.Equal(t, ErrForbidden, us.SuspendAs(admin.Claims, member.User.Id))
+	assert.Equal(t, ErrForbidden, us.UpdateAs(admin.Claims, UpdateUserParams{Id: &member.User.Id, FirstName: &name}))
+	assert.Equal(t, ErrForbidden, orgsv.UpdateAs(admin.Claims, UpdateOrgParams{Id: &o.Id, Name: &name}))
+
+	// Suspended members can't act, not even on themselves.
+	assert.Nil(t, orgsv.SuspendMember(o.Id, member.User.Id))
+	assert.Equal(t, ErrNotAuth, us.UpdateAs(member.Claims, UpdateUserParams{Id: &member.User.Id, FirstName: &name}))
+	assert.Nil(t, orgsv.RestoreMember(o.Id, member.User.Id))
+	assert.Nil(t, us.UpdateAs(member.Claims, UpdateUserParams{Id: &member.User.Id, FirstName: &name}))
+	role = RoleAdmin
+	assert.Nil(t, us.AssignRoleAs(owner.Claims, AssignRoleParams{Id: &admin.User.Id, Role: &role}))
+	assert.Nil(t, us.SuspendAs(owner.Claims, admin.User.Id))
+	assert.Equal(t, ErrNotAuth, us.RestoreAs(admin.Claims, member.User.Id))
+	assert.Equal(t, ErrNotAuth, us.UpdateAs(admin.Claims, UpdateUserParams{Id: &admin.User.Id, FirstName: &name}))
+
+	// Members removed from the org lose its permissions.
+	assert.Nil(t, us.RestoreAs(owner.Claims, admin.User.Id))
+	assert.Nil(t, orgsv.RemoveMember(o.Id, admin.User.Id))
+	assert.Equal(t, ErrNotAuth, us.SuspendAs(admin.Claims, member.User.Id))
+}
+
+func TestOrgs_UpdateAsParent(t *testing.T) {
+	parent, err := orgsv.Create(CreateOrgParams{Name: "Tenant Parent"})
+	assert.Nil(t, err)
+	child, err := orgsv.Create(CreateOrgParams{Name: "Tenant Child", ParentId: parent.Id})
+	assert.Nil(t, err)
+	other, err := orgsv.Create(CreateOrgParams{Name: "Other Tenant"})
+	assert.Nil(t, err)
+	password := "M0nk3yNutz5"
+	_, _, err = us.SignUp(SignUpParams{Email: "tenant-owner@mail.com", Password: password, OrgId: child.Id, Role: RoleOwner})
+	assert.Nil(t, err)
+	owner, err := us.SignIn(SignInParams{Email: "tenant-owner@mail.com", Password: password})
+	assert.Nil(t, err)
+
+	// The org can't be moved under another tenant or out of its parent's tree.
+	for _, parentId := range []int64{other.Id, 0} {
+		parentId := parentId
+		assert.Equal(t, ErrForbidden, orgsv.UpdateAs(owner.Claims, UpdateOrgParams{Id: &child.Id, ParentId: &parentId}))
+	}
+	got, err := orgsv.Get(child.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, parent.Id, got.ParentId)
+
+	name := "Tenant Child Ltd"
+	assert.Nil(t, orgsv.UpdateAs(owner.Claims, UpdateOrgParams{Id: &child.Id, Name: &name, ParentId: &parent.Id}))
+	got, err = orgsv.Get(child.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, name, got.Name)
+}
@@ -0,0 +1,110 @@
+package gus
+
+import (
+	"database/sql"
+	"time"
+)
+
+// CascadePolicy decides what happens to the users of an org when the org is suspended or deleted.
+type CascadePolicy int
+
+const (
+	// CascadeBlock (default) leaves the users untouched, they can't sign in while their org is suspended or deleted.
+	CascadeBlock CascadePolicy = iota
+	// CascadeUsers also suspends or deletes the users whose default org is the org, restoring or undeleting the
+	// org reverses it for only those users. Users of descendant orgs and members whose default org is another org
+	// are left to be blocked as with CascadeBlock.
+	CascadeUsers
+)
+
+type OrgOpts struct {
+	SuspendPolicy CascadePolicy
+	DeletePolicy  CascadePolicy
+	Auditor       Auditor         // Optional, records changes to orgs and their members.
+	Outbox        *Outbox         // Optional, events are added in the same transaction as the change for webhook delivery.
+	Attributes    AttributeSchema // Optional, the custom attributes or settings orgs may have.
+	// Attributes which orgs that haven't set them take from their nearest ancestor, e.g. a default locale.
+	Inherit []string
+	// Optional, canonicalizes invited emails to find their users, it should match UserOpts.Normalizer.
+	Normalizer *Normalizer
+}
+
+// Suspend suspends the org, users of the org and its descendants can't sign in.
+func (us *Orgs) Suspend(id int64) error {
+	return us.emit(&Event{Kind: OrgSuspended, OrgId: id}, AuditOrgSuspend, func(tx *sql.Tx) error {
+		err := us.Suspender.set(tx, "suspended", 1, id)
+		if err != nil || us.SuspendPolicy != CascadeUsers {
+			return err
+		}
+		_, err = tx.Exec("UPDATE users SET suspended = 1, suspended_by_org = ?, updated = ?, version = version + 1 WHERE org_id = ? AND suspended = 0 AND deleted = 0",
+			id, Milliseconds(time.Now()), id)
+		return err
+	})
+}
+
+// Restore reverses Suspend, users suspended individually stay suspended.
+func (us *Orgs) Restore(id int64) error {
+	return us.emit(&Event{Kind: OrgRestored, OrgId: id}, AuditOrgRestore, func(tx *sql.Tx) error {
+		err := us.Suspender.set(tx, "suspended", 0, id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE users SET suspended = 0, suspended_by_org = 0, updated = ?, version = version + 1 WHERE suspended_by_org = ?",
+			Milliseconds(time.Now()), id)
+		return err
+	})
+}
+
+// Delete soft deletes the org, users of the org and its descendants can't sign in.
+func (us *Orgs) Delete(id int64) error {
+	return us.emit(&Event{Kind: OrgDeleted, OrgId: id}, AuditOrgDelete, func(tx *sql.Tx) error {
+		err := us.Suspender.set(tx, "deleted", 1, id)
+		if err != nil || us.DeletePolicy != CascadeUsers {
+			return err
+		}
+		now := Milliseconds(time.Now())
+		_, err = tx.Exec("UPDATE users SET deleted = 1, deleted_by_org = ?, deleted_at = ?, updated = ?, version = version + 1 "+
+			"WHERE org_id = ? AND deleted = 0", id, now, now, id)
+		if err != nil {
+			return err
+		}
+		return releaseKeys(tx, "deleted_by_org = ?", id)
+	})
+}
+
+// UnDelete reverses Delete, users deleted individually stay deleted as do users whose email or username has been
+// taken by another user since.
+func (us *Orgs) UnDelete(id int64) error {
+	return us.emit(&Event{Kind: OrgUnDeleted, OrgId: id}, AuditOrgUnDelete, func(tx *sql.Tx) error {
+		err := us.Suspender.unDelete(tx, id)
+		if err != nil {
+			return err
+		}
+		var userIds []int64
+		rows, err := tx.Query("SELECT id FROM users WHERE deleted_by_org = ? AND deleted = 1", id)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var userId int64
+			if err = rows.Scan(&userId); err != nil {
+				rows.Close()
+				return err
+			}
+			userIds = append(userIds, userId)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		for _, userId := range userIds {
+			err = claimKeys(tx, us.normalizer(), userId)
+			if err != nil && err != ErrEmailTaken && err != ErrUsernameTaken {
+				return err
+			}
+		}
+		_, err = tx.Exec("UPDATE users SET deleted = 0, deleted_by_org = 0, updated = ?, version = version + 1 WHERE deleted_by_org = ? "+
+			"AND email_norm IS NOT NULL", Milliseconds(time.Now()), id)
+		return err
+	})
+}
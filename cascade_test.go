@@ -0,0 +1,81 @@
+package gus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestOrgs_Cascade(t *testing.T) {
+	password := "M0nk3yNutz5"
+	o, err := orgsv.Create(CreateOrgParams{Name: "Cascade Co."})
+	assert.Nil(t, err)
+	u, _, err := us.SignUp(SignUpParams{Email: "cascade@mail.com", Password: password, OrgId: o.Id})
+	assert.Nil(t, err)
+	loner, _, err := us.SignUp(SignUpParams{Email: "cascade-suspended@mail.com", Password: password, OrgId: o.Id})
+	assert.Nil(t, err)
+	assert.Nil(t, us.Suspend(loner.Id))
+
+	// Block (default), a deleted org is treated like a suspended one
+	assert.Nil(t, orgsv.Delete(o.Id))
+	uc, _, err := us.GetByUsername(u.Email)
+	assert.Nil(t, err)
+	assert.True(t, uc.OrgSuspended)
+	_, err = us.SignIn(SignInParams{Email: u.Email, Password: password})
+	assert.Equal(t, ErrNotAuth, err)
+	assert.Nil(t, orgsv.UnDelete(o.Id))
+	_, err = us.SignIn(SignInParams{Email: u.Email, Password: password})
+	assert.Nil(t, err)
+
+	orgsv.SuspendPolicy = CascadeUsers
+	orgsv.DeletePolicy = CascadeUsers
+	defer func() { orgsv.OrgOpts = OrgOpts{} }()
+
+	assert.Nil(t, orgsv.Suspend(o.Id))
+	u, err = us.Get(u.Id)
+	assert.Nil(t, err)
+	assert.True(t, u.Suspended)
+	assert.Nil(t, orgsv.Restore(o.Id))
+	u, err = us.Get(u.Id)
+	assert.Nil(t, err)
+	assert.False(t, u.Suspended)
+	// Suspended individually so stays suspended
+	loner, err = us.Get(loner.Id)
+	assert.Nil(t, err)
+	assert.True(t, loner.Suspended)
+
+	assert.Nil(t, orgsv.Delete(o.Id))
+	_, err = us.Get(u.Id)
+	assert.Equal(t, ErrNotFound, err)
+	assert.Nil(t, orgsv.UnDelete(o.Id))
+	_, err = us.Get(u.Id)
+	assert.Nil(t, err)
+}
+
+func TestOrgs_CascadeThenIndividual(t *testing.T) {
+	password := "M0nk3yNutz5"
+	o, err := orgsv.Create(CreateOrgParams{Name: "Cascade Then Individual Co."})
+	assert.Nil(t, err)
+	u, _, err := us.SignUp(SignUpParams{Email: "cascade-individual@mail.com", Password: password, OrgId: o.Id})
+	assert.Nil(t, err)
+	orgsv.SuspendPolicy = CascadeUsers
+	orgsv.DeletePolicy = CascadeUsers
+	defer func() { orgsv.OrgOpts = OrgOpts{} }()
+
+	// Restored then suspended individually so the org's restore leaves the user suspended.
+	assert.Nil(t, orgsv.Suspend(o.Id))
+	assert.Nil(t, us.Restore(u.Id))
+	assert.Nil(t, us.Suspend(u.Id))
+	assert.Nil(t, orgsv.Restore(o.Id))
+	got, err := us.Get(u.Id)
+	assert.Nil(t, err)
+	assert.True(t, got.Suspended)
+	assert.Nil(t, us.Restore(u.Id))
+
+	// Likewise undeleted then deleted individually.
+	assert.Nil(t, orgsv.Delete(o.Id))
+	assert.Nil(t, us.UnDelete(u.Id))
+	assert.Nil(t, us.Delete(u.Id))
+	assert.Nil(t, orgsv.UnDelete(o.Id))
+	_, err = us.Get(u.Id)
+	assert.Equal(t, ErrNotFound, err)
+}
@@ -6,6 +6,7 @@
 package gus
 
 import (
+	crand "crypto/rand"
 	"math/rand"
 	"time"
 )
@@ -48,3 +49,22 @@ func RandStringBytesMaskImprSrc(n int64) string {
 	}
 	return string(b)
 }
+
+// SecureRandString reads from crypto/rand, it's the default for codes and tokens that grant access such as
+// invite codes, reset tokens and email change tokens.
+func SecureRandString(n int64) string {
+	b := make([]byte, n)
+	buf := make([]byte, n)
+	for i := int64(0); i < n; {
+		if _, err := crand.Read(buf); err != nil {
+			panic(err)
+		}
+		for _, c := range buf {
+			if idx := int(c & letterIdxMask); idx < len(letterBytes) && i < n {
+				b[i] = letterBytes[idx]
+				i++
+			}
+		}
+	}
+	return string(b)
+}
@@ -2,9 +2,7 @@ package gus
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"regexp"
 	"strings"
 	"time"
 )
@@ -29,7 +27,6 @@ type DbOpts struct {
 
 var (
 	driverName string
-	sqlCheck   = regexp.MustCompile("^[A-Za-z.]+$")
 	sqlErr     = ErrInvalid("Invalid order params.")
 	seeds      = map[string]string{
 		"mysql":   SeedMySql,
@@ -58,11 +55,22 @@ func GetDb(o DbOpts) (*sql.DB, error) {
 
 // Seed executes sql prior to app start. Not to be exposed to client apis.
 func Seed(db *sql.DB, xtraSeedSql ...string) error {
-	_, err := db.Exec(fmt.Sprintf("%s\n%s", seeds[driverName], strings.Join(xtraSeedSql, "\n")))
+	_, err := db.Exec(seeds[driverName])
 	if err != nil {
 		return err
 	}
-	return nil
+	err = seedRoles(db)
+	if err != nil {
+		return err
+	}
+	err = seedSearch(db)
+	if err != nil {
+		return err
+	}
+	if len(xtraSeedSql) > 0 {
+		_, err = db.Exec(strings.Join(xtraSeedSql, "\n"))
+	}
+	return err
 }
 
 func CheckNotFound(err error) error {
@@ -86,14 +94,33 @@ func CheckUpdated(res sql.Result, err error) error {
 	return nil
 }
 
+// checkVersion returns a ConflictError if the row has changed from the expected version or ErrNotFound if
+// it's gone, expected may be nil when the caller doesn't care.
+func checkVersion(q queryer, table string, id int64, expected *int64) error {
+	if expected == nil {
+		return nil
+	}
+	var v int64
+	err := CheckNotFound(q.QueryRow("SELECT version FROM "+table+" WHERE id = ? AND deleted = 0", id).Scan(&v))
+	if err != nil {
+		return err
+	}
+	if v != *expected {
+		return &ConflictError{Version: v}
+	}
+	return nil
+}
+
 type SortDir string
 
 type ListArgs struct {
-	Size      int     `json:"size"`      // Page size
-	Page      int     `json:"page"`      // Zero-indexed page
-	OrderBy   string  `json:"sort_by"`   // Order by comma separated columns
-	Direction SortDir `json:"direction"` // ASC or DESC
-	Deleted   bool    `json:"deleted"`   // Include deleted in results
+	Size      int     `json:"size"`       // Page size
+	Page      int     `json:"page"`       // Zero-indexed page
+	OrderBy   string  `json:"sort_by"`    // Comma separated fields, prefix with '-' for DESC or '+' for ASC
+	Direction SortDir `json:"direction"`  // ASC or DESC for fields without a prefix
+	Deleted   bool    `json:"deleted"`    // Include deleted in results
+	Cursor    string  `json:"cursor"`     // From Cursors of a previous page, when given Page is ignored
+	SkipCount bool    `json:"skip_count"` // Don't count the total, which is then -1
 }
 
 func (p *ListArgs) ApplyDefaults() {
@@ -111,31 +138,20 @@ func (p *ListArgs) ApplyDefaults() {
 	}
 }
 
-// ApplyUpdates will apply updates to an 'original' struct and update fields based on an 'updates' struct
-// The 'updates' struct should have point fields and should also serialize to and from json the same as the
-// Intended destination fields.
-func ApplyUpdates(original interface{}, updates interface{}) error {
-	p, err := json.Marshal(updates)
-	if err != nil {
-		return err
-	}
-	_ = json.Unmarshal(p, original)
-	return nil
-}
-
 // GetRows returns a *sql.Rows iterator after adding limit and offset, results are sorted by default 'updated' desc.
+// Only the fields in sortable can be sorted by.
 // Sql added sample: + ' ORDER by updated DESC LIMIT 20 OFFSET 1'
-func GetRows(db *sql.DB, query string, lp *ListArgs, args ...interface{}) (*sql.Rows, error) {
-	lp.ApplyDefaults()
-	if !sqlCheck.MatchString(lp.OrderBy) || !sqlCheck.MatchString(string(lp.Direction)) {
-		return nil, sqlErr
+func GetRows(db *sql.DB, query string, sortable SortFields, lp *ListArgs, args ...interface{}) (*sql.Rows, error) {
+	keys, err := lp.SortKeys(sortable)
+	if err != nil {
+		return nil, err
 	}
-	query += fmt.Sprintf(" ORDER BY %s %s LIMIT ? OFFSET ?", lp.OrderBy, lp.Direction)
+	query += fmt.Sprintf(" ORDER BY %s LIMIT ? OFFSET ?", orderBy(keys, "", false))
 	args = append(args, lp.Size, lp.Page*lp.Size)
 	stmt, err := db.Prepare(query)
 	if err != nil {
 		if err.Error() == ErrStringNoSuchColumn {
-			return nil, ErrInvalid(fmt.Sprintf(err.Error()))
+			return nil, ErrInvalid(err.Error())
 		}
 		return nil, err
 	}
@@ -0,0 +1,143 @@
+package gus
+
+import (
+	"database/sql"
+	"github.com/asaskevich/govalidator"
+	"time"
+)
+
+var (
+	ErrInvalidEmailChangeToken = ErrInvalid("Invalid email change token.")
+	ErrEmailUnchanged          = ErrInvalid("The new email is the same as the current email.")
+	ErrEmailChangeUnconfirmed  = ErrInvalid("Email changes must be confirmed, use RequestEmailChange.")
+)
+
+// EmailChange is a pending change of a user's email address awaiting confirmation from the new address.
+type EmailChange struct {
+	Id       int64  `json:"id"`
+	UserId   int64  `json:"user_id"`
+	OldEmail string `json:"old_email"`
+	NewEmail string `json:"new_email"`
+	Created  int64  `json:"created"`
+}
+
+// RequestEmailChange stores a pending change of email for the user and returns a confirmation token. The token
+// is sent to the new address and the old address notified (when a Notifier is configured), the change is only
+// applied once the token is passed to ConfirmEmailChange. Any previous pending change for the user is discarded.
+func (us *Users) RequestEmailChange(userId int64, newEmail string) (string, error) {
+	newEmail = us.normalizer().Clean(newEmail)
+	if !govalidator.IsEmail(newEmail) {
+		return "", ErrEmailInvalid
+	}
+	u, err := us.Get(userId)
+	if err != nil {
+		return "", err
+	}
+	if u.Passive {
+		return "", ErrNotAuth
+	}
+	newKey, err := us.normalizer().Email(newEmail)
+	if err != nil {
+		return "", err
+	}
+	if oldKey, _ := us.normalizer().Email(u.Email); oldKey == newKey {
+		return "", ErrEmailUnchanged
+	}
+	token := us.PassGen(128)
+	e := &Event{Kind: EmailChangeRequested, UserId: u.Id, User: u, Changes: map[string]Change{"email": {From: u.Email, To: newEmail}}}
+	err = us.emit(e, AuditEmailRequest, func(tx *sql.Tx) error {
+		exists, err := us.exists(tx, us.emailChangeExists(newEmail))
+		if exists {
+			return err
+		}
+		_, err = tx.Exec("UPDATE email_changes SET deleted = 1 WHERE user_id = ?", u.Id)
+		if err != nil {
+			return err
+		}
+		stmt, err := tx.Prepare("INSERT INTO email_changes (user_id, old_email, new_email, token, created, deleted) values (?, ?, ?, ?, ?, ?)")
+		if err != nil {
+			return err
+		}
+		_, err = stmt.Exec(u.Id, u.Email, newEmail, token, Milliseconds(time.Now()), 0)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	notify(us.Notifier, Notification{Kind: NotifyEmailChangeConfirm, To: newEmail, User: u, Token: token})
+	notify(us.Notifier, Notification{Kind: NotifyEmailChangeNotice, To: u.Email, User: u, Data: map[string]string{"new_email": newEmail}})
+	return token, nil
+}
+
+// ConfirmEmailChange applies the pending email change identified by token. The new email (and username when
+// UsernameIsEmail) is checked for uniqueness again since it may have been taken since the change was requested.
+func (us *Users) ConfirmEmailChange(token string) (*EmailChange, error) {
+	if token == "" {
+		return nil, ErrInvalidEmailChangeToken
+	}
+	var ec EmailChange
+	e := &Event{Kind: EmailChanged}
+	err := us.emit(e, AuditEmailChange, func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare("SELECT id, user_id, old_email, new_email, created FROM email_changes WHERE token = ? AND deleted = 0 LIMIT 1")
+		if err != nil {
+			return err
+		}
+		var oldEmail sql.NullString
+		err = stmt.QueryRow(token).Scan(&ec.Id, &ec.UserId, &oldEmail, &ec.NewEmail, &ec.Created)
+		if err == sql.ErrNoRows {
+			return ErrInvalidEmailChangeToken
+		}
+		if err != nil {
+			return err
+		}
+		ec.OldEmail = oldEmail.String
+		e.UserId, e.Changes = ec.UserId, map[string]Change{"email": {From: ec.OldEmail, To: ec.NewEmail}}
+		if Milliseconds(time.Now()) > ec.Created+us.EmailChangeExpiry*1000 {
+			return ErrTokenExpired
+		}
+		exists, err := us.exists(tx, us.emailChangeExists(ec.NewEmail))
+		if exists {
+			return err
+		}
+		var username string
+		err = CheckNotFound(tx.QueryRow("SELECT COALESCE(username, '') FROM users WHERE id = ? AND deleted = 0", ec.UserId).Scan(&username))
+		if err != nil {
+			return err
+		}
+		if *us.UsernameIsEmail && username != ec.NewEmail {
+			e.Changes["username"] = Change{From: username, To: ec.NewEmail}
+			username = ec.NewEmail
+		}
+		emailKey, usernameKey, err := us.normalizer().keys(ec.NewEmail, username)
+		if err != nil {
+			return err
+		}
+		q := "UPDATE users SET email = ?, username = ?, email_norm = ?, username_norm = ?, updated = ?, version = version + 1 " +
+			"WHERE id = ? AND deleted = 0"
+		args := []interface{}{ec.NewEmail, username, emailKey, usernameKey, Milliseconds(time.Now()), ec.UserId}
+		err = CheckUpdated(tx.Exec(q, args...))
+		if err != nil {
+			return err
+		}
+		if err = indexUser(tx, ec.UserId); err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE email_changes SET deleted = 1 WHERE user_id = ?", ec.UserId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if u, err := us.Get(ec.UserId); err == nil {
+		notify(us.Notifier, Notification{Kind: NotifyEmailChanged, To: ec.OldEmail, User: u, Data: map[string]string{"new_email": ec.NewEmail}})
+	}
+	return &ec, nil
+}
+
+func (us *Users) emailChangeExists(newEmail string) ExistsParams {
+	p := ExistsParams{Email: newEmail}
+	if *us.UsernameIsEmail {
+		p.Username = newEmail
+	}
+	return p
+}
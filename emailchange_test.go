@@ -0,0 +1,43 @@
+package gus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestUsers_EmailChange(t *testing.T) {
+	u, _, err := us.SignUp(SignUpParams{Email: "change@mail.com", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+
+	// Unchanged until confirmed
+	token, err := us.RequestEmailChange(u.Id, "changed@mail.com")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, token)
+	u, err = us.Get(u.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "change@mail.com", u.Email)
+
+	ec, err := us.ConfirmEmailChange(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "change@mail.com", ec.OldEmail)
+	u, err = us.Get(u.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "changed@mail.com", u.Email)
+	assert.Equal(t, "changed@mail.com", u.Username)
+
+	// Can't use same token twice
+	_, err = us.ConfirmEmailChange(token)
+	assert.Equal(t, ErrInvalidEmailChangeToken, err)
+
+	// Email taken between request and confirmation
+	token, err = us.RequestEmailChange(u.Id, "taken-later@mail.com")
+	assert.Nil(t, err)
+	_, _, err = us.SignUp(SignUpParams{Email: "taken-later@mail.com", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+	_, err = us.ConfirmEmailChange(token)
+	assert.Equal(t, ErrEmailTaken, err)
+
+	// Email already taken
+	_, err = us.RequestEmailChange(u.Id, "taken-later@mail.com")
+	assert.Equal(t, ErrEmailTaken, err)
+}
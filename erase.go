@@ -0,0 +1,207 @@
+package gus
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Erase removes the personal data of a user, deleted or not, to honour a request to be forgotten. The user's row
+// is kept, deleted and stripped of their email, username, names, phone and password, so audit events and other
+// records which refer to the user by id stay intact. Their attributes, memberships, password resets, pending
+// email changes, invitations and sign-in attempts are removed and the ips and changes of audit events about or
+// by them are cleared, as are the changes of org events which name their email such as invitations. Outbox
+// events about them or naming their email, delivered or not, keep their kind and ids but lose the user and
+// changes, so webhooks not yet delivered get the stripped events. Erased users can't be undeleted.
+func (us *Users) Erase(id int64) error {
+	return us.emit(&Event{Kind: UserErased, UserId: id}, AuditUserErase, func(tx *sql.Tx) error {
+		return erase(tx, us.normalizer(), id)
+	})
+}
+
+func erase(tx *sql.Tx, n *Normalizer, id int64) error {
+	var email, username string
+	err := CheckNotFound(lookupUsers(allUsers).where("u.erased = 0").byId(id).
+		row(tx, "COALESCE(u.email, ''), COALESCE(u.username, '')", "users u").Scan(&email, &username))
+	if err != nil {
+		return err
+	}
+	// Deleted users don't hold their email_norm, see releaseKeys.
+	emailKey, err := n.Email(email)
+	if err != nil {
+		emailKey = n.Username(email)
+	}
+	now := Milliseconds(time.Now())
+	_, err = tx.Exec("UPDATE users SET username = NULL, email = NULL, email_norm = NULL, username_norm = NULL, "+
+		"first_name = '', last_name = '', phone = '', password_hash = NULL, invite_code = NULL, "+
+		"deleted = 1, erased = ?, updated = ?, version = version + 1 WHERE id = ?", now, now, id)
+	if err != nil {
+		return err
+	}
+	related := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DELETE FROM user_attributes WHERE user_id = ?", []interface{}{id}},
+		{"DELETE FROM memberships WHERE user_id = ?", []interface{}{id}},
+		{"DELETE FROM password_resets WHERE user_id = ?", []interface{}{id}},
+		{"DELETE FROM email_changes WHERE user_id = ?", []interface{}{id}},
+		{"DELETE FROM org_invitations WHERE email_norm = ?", []interface{}{emailKey}},
+		{"DELETE FROM password_attempts WHERE username IN (?, ?)", []interface{}{n.Username(email), n.Username(username)}},
+		{"UPDATE audit_events SET ip = '', changes = '' WHERE target_type = ? AND target_id = ?", []interface{}{TargetUser, id}},
+		{"UPDATE audit_events SET ip = '' WHERE actor_id = ?", []interface{}{id}},
+	}
+	for _, d := range related {
+		if _, err = tx.Exec(d.query, d.args...); err != nil {
+			return err
+		}
+	}
+	if err = scrubOrgAudit(tx, n, emailKey); err != nil {
+		return err
+	}
+	if err = scrubOutbox(tx, n, id, emailKey); err != nil {
+		return err
+	}
+	return indexUser(tx, id)
+}
+
+// scrubOrgAudit clears the changes of audit events about orgs which name the email whose key is emailKey, e.g.
+// invitations. Events with an email are checked one by one since it may have been given in another form.
+func scrubOrgAudit(tx *sql.Tx, n *Normalizer, emailKey string) error {
+	rows, err := tx.Query("SELECT id, changes FROM audit_events WHERE target_type = ? AND changes LIKE '%@%'", TargetOrg)
+	if err != nil {
+		return err
+	}
+	ids := []int64{}
+	for rows.Next() {
+		var eventId int64
+		var data string
+		if err = rows.Scan(&eventId, &data); err != nil {
+			rows.Close()
+			return err
+		}
+		var changes map[string]Change
+		if json.Unmarshal([]byte(data), &changes) == nil && names(n, changes, emailKey) {
+			ids = append(ids, eventId)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, eventId := range ids {
+		if _, err = tx.Exec("UPDATE audit_events SET changes = '' WHERE id = ?", eventId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scrubOutbox strips the user and changes from the outbox events about the user, and the changes from those
+// about no user which name the email whose key is emailKey.
+func scrubOutbox(tx *sql.Tx, n *Normalizer, id int64, emailKey string) error {
+	rows, err := tx.Query("SELECT id, kind, user_id, payload FROM outbox WHERE user_id = ? OR (user_id = 0 AND payload LIKE '%@%')", id)
+	if err != nil {
+		return err
+	}
+	events := map[int64]Event{}
+	for rows.Next() {
+		var outboxId int64
+		var payload string
+		var e Event
+		if err = rows.Scan(&outboxId, &e.Kind, &e.UserId, &payload); err != nil {
+			rows.Close()
+			return err
+		}
+		// A payload which can't be read is replaced when it's about the user, Dispatch can't send it anyway.
+		if json.Unmarshal([]byte(payload), &e) != nil && e.UserId != id {
+			continue
+		}
+		events[outboxId] = e
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for outboxId, e := range events {
+		if e.UserId != id && !names(n, e.Changes, emailKey) {
+			continue
+		}
+		e.User, e.Changes = nil, nil
+		p, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec("UPDATE outbox SET payload = ? WHERE id = ?", string(p), outboxId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// names reports whether a value of changes is an email whose key is emailKey.
+func names(n *Normalizer, changes map[string]Change, emailKey string) bool {
+	if emailKey == "" {
+		return false
+	}
+	for _, c := range changes {
+		for _, v := range []interface{}{c.From, c.To} {
+			if s, ok := v.(string); ok {
+				if key, err := n.Email(s); err == nil && key == emailKey {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// PurgeDeleted erases users which were deleted more than retention ago, it returns the number erased. Users
+// deleted before MigrateErased added deleted_at go by when they were last updated, deleted users can't be changed.
+func (us *Users) PurgeDeleted(retention time.Duration) (int, error) {
+	before := Milliseconds(time.Now().Add(-retention))
+	query, args := lookupUsers(deletedUsers).
+		where("(u.deleted_at > 0 AND u.deleted_at < ?) OR (u.deleted_at = 0 AND u.updated < ?)", before, before).
+		query("u.id", "users u")
+	rows, err := us.db.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	erased := 0
+	for _, id := range ids {
+		if err = us.Erase(id); err != nil {
+			return erased, err
+		}
+		erased++
+	}
+	return erased, nil
+}
+
+// RunPurge calls PurgeDeleted every interval until stop is closed.
+func (us *Users) RunPurge(retention time.Duration, interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if _, err := us.PurgeDeleted(retention); err != nil {
+			LogErr(err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package gus
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestErase(t *testing.T) {
+	password := "M0nk3yNutz5"
+	al := NewAuditLog(us.db)
+	ua := us.By(0, "10.0.0.9")
+	ua.Auditor = al
+	o, err := orgsv.Create(CreateOrgParams{Name: "Erasure"})
+	assert.Nil(t, err)
+	u, _, err := ua.SignUp(SignUpParams{Email: "forget.me@mail.com", FirstName: "Forget", Phone: "555 0100", Password: password, OrgId: o.Id})
+	assert.Nil(t, err)
+	_, err = ua.ResetPassword(ResetPasswordParams{Email: "forget.me@mail.com"})
+	assert.Nil(t, err)
+	_, err = ua.SignIn(SignInParams{Email: "Forget.Me@mail.com", Password: "wrong"})
+	assert.Equal(t, ErrNotAuth, err)
+
+	assert.Nil(t, ua.Erase(u.Id))
+	_, err = us.Get(u.Id)
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, ErrNotFound, us.UnDelete(u.Id))
+	assert.Equal(t, ErrNotFound, us.Erase(u.Id))
+	var email, username sql.NullString
+	var firstName, phone string
+	assert.Nil(t, us.db.QueryRow("SELECT email, username, first_name, phone FROM users WHERE id = ?", u.Id).Scan(&email, &username, &firstName, &phone))
+	assert.False(t, email.Valid)
+	assert.False(t, username.Valid)
+	assert.Equal(t, "", firstName)
+	assert.Equal(t, "", phone)
+	for _, q := range []string{
+		"SELECT count(*) FROM password_resets WHERE user_id = ?",
+		"SELECT count(*) FROM memberships WHERE user_id = ?",
+		"SELECT count(*) FROM audit_events WHERE target_id = ? AND target_type = 'user' AND action <> 'user.erase' AND (ip <> '' OR changes <> '')",
+	} {
+		var count int
+		assert.Nil(t, us.db.QueryRow(q, u.Id).Scan(&count))
+		assert.Equal(t, 0, count, q)
+	}
+	var attempts int
+	assert.Nil(t, us.db.QueryRow("SELECT count(*) FROM password_attempts WHERE username = 'forget.me@mail.com'").Scan(&attempts))
+	assert.Equal(t, 0, attempts)
+	// The audit trail keeps the erasure itself.
+	list, err := al.List(ListAuditParams{AuditFilters: AuditFilters{TargetType: TargetUser, TargetId: u.Id, Action: AuditUserErase}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), list.Total)
+	// Erased users are listed without personal data.
+	users, err := us.List(ListUsersParams{ListArgs: ListArgs{Deleted: true}, UserFilters: UserFilters{OrgId: o.Id}})
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(users.Items)) {
+		assert.Equal(t, u.Id, users.Items[0].Id)
+		assert.Equal(t, "", users.Items[0].Email)
+	}
+
+	// The email can be used again.
+	_, _, err = us.SignUp(SignUpParams{Email: "forget.me@mail.com", Password: password, OrgId: o.Id})
+	assert.Nil(t, err)
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	password := "M0nk3yNutz5"
+	old, _, err := us.SignUp(SignUpParams{Email: "purge.old@mail.com", Password: password})
+	assert.Nil(t, err)
+	recent, _, err := us.SignUp(SignUpParams{Email: "purge.recent@mail.com", Password: password})
+	assert.Nil(t, err)
+	legacy, _, err := us.SignUp(SignUpParams{Email: "purge.legacy@mail.com", Password: password})
+	assert.Nil(t, err)
+	assert.Nil(t, us.Delete(old.Id))
+	assert.Nil(t, us.Delete(recent.Id))
+	assert.Nil(t, us.Delete(legacy.Id))
+	longAgo := Milliseconds(time.Now().Add(-48 * time.Hour))
+	_, err = us.db.Exec("UPDATE users SET deleted_at = ? WHERE id = ?", longAgo, old.Id)
+	assert.Nil(t, err)
+	// Deleted before deleted_at was recorded.
+	_, err = us.db.Exec("UPDATE users SET deleted_at = 0, updated = ? WHERE id = ?", longAgo, legacy.Id)
+	assert.Nil(t, err)
+
+	n, err := us.PurgeDeleted(24 * time.Hour)
+	assert.Nil(t, err)
+	assert.True(t, n >= 1)
+	var erased int64
+	assert.Nil(t, us.db.QueryRow("SELECT erased FROM users WHERE id = ?", old.Id).Scan(&erased))
+	assert.True(t, erased > 0)
+	assert.Nil(t, us.db.QueryRow("SELECT erased FROM users WHERE id = ?", legacy.Id).Scan(&erased))
+	assert.True(t, erased > 0)
+	assert.Nil(t, us.db.QueryRow("SELECT erased FROM users WHERE id = ?", recent.Id).Scan(&erased))
+	assert.Equal(t, int64(0), erased)
+	assert.Nil(t, us.UnDelete(recent.Id))
+}
+
+func TestErase_Outbox(t *testing.T) {
+	uo := NewUsers(us.db, UserOpts{Outbox: NewOutbox(us.db, OutboxOpts{}), AuthAttempts: 5})
+	u, _, err := uo.SignUp(SignUpParams{Email: "forget.outbox@mail.com", FirstName: "Forget", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+	name := "Outboxed"
+	assert.Nil(t, uo.Update(UpdateUserParams{Id: &u.Id, LastName: &name}))
+	assert.Nil(t, uo.Erase(u.Id))
+
+	rows, err := us.db.Query("SELECT payload FROM outbox WHERE user_id = ? ORDER BY id", u.Id)
+	assert.Nil(t, err)
+	defer rows.Close()
+	kinds := []EventKind{}
+	for rows.Next() {
+		var payload string
+		assert.Nil(t, rows.Scan(&payload))
+		assert.NotContains(t, payload, "forget.outbox@mail.com")
+		assert.NotContains(t, payload, "Outboxed")
+		var e Event
+		assert.Nil(t, json.Unmarshal([]byte(payload), &e))
+		kinds = append(kinds, e.Kind)
+	}
+	assert.Equal(t, []EventKind{UserCreated, UserUpdated, UserErased}, kinds)
+}
+
+func TestErase_OrgEvents(t *testing.T) {
+	al := NewAuditLog(us.db)
+	ob := NewOutbox(us.db, OutboxOpts{})
+	oa := orgsv.By(0, "10.0.0.4")
+	oa.Auditor, oa.Outbox = al, ob
+	o, err := oa.Create(CreateOrgParams{Name: "Erased Invitee Inc."})
+	assert.Nil(t, err)
+	u, _, err := us.SignUp(SignUpParams{Email: "erased.invitee@mail.com", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+	_, _, err = us.SignUp(SignUpParams{Email: "kept.invitee@mail.com", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+	_, err = oa.InviteMember(o.Id, "Erased.Invitee@mail.com", RoleMember)
+	assert.Nil(t, err)
+	_, err = oa.InviteMember(o.Id, "kept.invitee@mail.com", RoleMember)
+	assert.Nil(t, err)
+
+	assert.Nil(t, us.Erase(u.Id))
+	res, err := al.List(ListAuditParams{AuditFilters: AuditFilters{TargetType: TargetOrg, TargetId: o.Id, Action: AuditMemberInvite},
+		ListArgs: ListArgs{Direction: DirectionAsc}})
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(res.Items)) {
+		assert.Nil(t, res.Items[0].Changes)
+		assert.Equal(t, "10.0.0.4", res.Items[0].Ip)
+		assert.Equal(t, Change{To: "kept.invitee@mail.com"}, res.Items[1].Changes["email"])
+	}
+	var erased, kept int
+	assert.Nil(t, us.db.QueryRow("SELECT count(*) FROM outbox WHERE kind = ? AND payload LIKE '%erased.invitee%'", MemberInvited).Scan(&erased))
+	assert.Nil(t, us.db.QueryRow("SELECT count(*) FROM outbox WHERE kind = ? AND payload LIKE '%kept.invitee%'", MemberInvited).Scan(&kept))
+	assert.Equal(t, 0, erased)
+	assert.Equal(t, 1, kept)
+}
@@ -4,10 +4,11 @@ import "strings"
 
 var (
 	ErrNotAuth         = &NotAuthenticatedError{}
+	ErrForbidden       = &ForbiddenError{}
 	ErrNotFound        = &NotFoundError{}
 	ErrCantDeleteSelf  = ErrInvalid("You can't delete yourself.")
 	ErrCantSuspendSelf = ErrInvalid("You can't suspend yourself.")
-	ErrTokenExpired = ErrInvalid("That access token has expired.")
+	ErrTokenExpired    = ErrInvalid("That access token has expired.")
 )
 
 type NotAuthenticatedError struct {
@@ -17,6 +18,14 @@ func (n *NotAuthenticatedError) Error() string {
 	return "Not Authenticated"
 }
 
+// ForbiddenError is returned when an authenticated user isn't allowed to perform an operation.
+type ForbiddenError struct {
+}
+
+func (f *ForbiddenError) Error() string {
+	return "Forbidden"
+}
+
 type RateLimitExceededError struct {
 	Messages []string `json:"messages"`
 }
@@ -32,6 +41,15 @@ func (n *NotFoundError) Error() string {
 	return "Not found"
 }
 
+// ConflictError is returned by updates made against a version of a user or org which has since changed.
+type ConflictError struct {
+	Version int64 `json:"version"` // The current version.
+}
+
+func (c *ConflictError) Error() string {
+	return "This was changed by someone else, reload it and try again."
+}
+
 func ErrInvalid(messages ...string) error {
 	return &ValidationError{Messages: messages}
 }
@@ -0,0 +1,216 @@
+package gus
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type EventKind string
+
+const (
+	UserCreated            EventKind = "user.created"
+	UserSignedIn           EventKind = "user.signed_in"
+	UserUpdated            EventKind = "user.updated"
+	UserSuspended          EventKind = "user.suspended"
+	UserRestored           EventKind = "user.restored"
+	UserDeleted            EventKind = "user.deleted"
+	UserUnDeleted          EventKind = "user.undeleted"
+	UserErased             EventKind = "user.erased"
+	RoleAssigned           EventKind = "user.role_assigned"
+	PasswordChanged        EventKind = "user.password_changed"
+	PasswordResetRequested EventKind = "user.password_reset_requested"
+	EmailChangeRequested   EventKind = "user.email_change_requested"
+	EmailChanged           EventKind = "user.email_changed"
+	OrgCreated             EventKind = "org.created"
+	OrgUpdated             EventKind = "org.updated"
+	OrgSuspended           EventKind = "org.suspended"
+	OrgRestored            EventKind = "org.restored"
+	OrgDeleted             EventKind = "org.deleted"
+	OrgUnDeleted           EventKind = "org.undeleted"
+	MemberAdded            EventKind = "org.member_added"
+	MemberRemoved          EventKind = "org.member_removed"
+	MemberSuspended        EventKind = "org.member_suspended"
+	MemberRestored         EventKind = "org.member_restored"
+	MemberInvited          EventKind = "org.member_invited"
+	InvitationRefused      EventKind = "org.invitation_declined" // Accepted invitations are MemberAdded events.
+	InviteCreated          EventKind = "org.invite_created"
+	InviteRevoked          EventKind = "org.invite_revoked"
+	RoleCreated            EventKind = "org.role_created" // OrgId is 0 for roles available to every org.
+	RolePermissionsSet     EventKind = "org.role_permissions_set"
+	RoleDeleted            EventKind = "org.role_deleted"
+)
+
+// Event describes a change to a user or org. UserId is 0 for before hooks of UserCreated and OrgId is 0 for
+// before hooks of OrgCreated since the rows don't exist yet. UserId of EmailChanged and OrgId of InviteRevoked are
+// also 0 for before hooks, they're looked up in the transaction.
+type Event struct {
+	Kind    EventKind         `json:"kind"`
+	ActorId int64             `json:"actor_id"` // Set when the operation was performed via By or an *As method.
+	UserId  int64             `json:"user_id,omitempty"`
+	OrgId   int64             `json:"org_id,omitempty"`
+	User    *User             `json:"user,omitempty"` // The user after the change where gus has it at hand.
+	Org     *Org              `json:"org,omitempty"`
+	Changes map[string]Change `json:"changes,omitempty"`
+	Created int64             `json:"created"`
+}
+
+// BeforeHook runs synchronously before an operation, returning an error (usually a ValidationError made with
+// ErrInvalid) vetoes the operation and is returned to the caller.
+type BeforeHook func(e Event) error
+
+// AfterHook runs in its own goroutine once an operation has succeeded.
+type AfterHook func(e Event)
+
+func NewHooks() *Hooks {
+	return &Hooks{before: map[EventKind][]BeforeHook{}, after: map[EventKind][]AfterHook{}}
+}
+
+// Hooks is a registry of subscribers to events, Users and Orgs each have their own.
+type Hooks struct {
+	mu     sync.RWMutex
+	wg     sync.WaitGroup
+	before map[EventKind][]BeforeHook
+	after  map[EventKind][]AfterHook
+}
+
+// Before subscribes f to run before operations of the kinds, in the order subscribed.
+func (h *Hooks) Before(f BeforeHook, kinds ...EventKind) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, k := range kinds {
+		h.before[k] = append(h.before[k], f)
+	}
+}
+
+// After subscribes f to run after operations of the kinds.
+func (h *Hooks) After(f AfterHook, kinds ...EventKind) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, k := range kinds {
+		h.after[k] = append(h.after[k], f)
+	}
+}
+
+// Wait blocks until every after hook which has started has returned, e.g. before shutting down.
+func (h *Hooks) Wait() {
+	h.wg.Wait()
+}
+
+func (h *Hooks) runBefore(e Event) error {
+	h.mu.RLock()
+	hooks := h.before[e.Kind]
+	h.mu.RUnlock()
+	if e.Created == 0 {
+		e.Created = Milliseconds(time.Now())
+	}
+	for _, f := range hooks {
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Hooks) runAfter(e Event) {
+	h.mu.RLock()
+	hooks := h.after[e.Kind]
+	h.mu.RUnlock()
+	if e.Created == 0 {
+		e.Created = Milliseconds(time.Now())
+	}
+	for _, f := range hooks {
+		h.wg.Add(1)
+		go func(f AfterHook) {
+			defer h.wg.Done()
+			defer func() {
+				if p := recover(); p != nil {
+					LogErr(fmt.Errorf("after hook for %s panicked: %v", e.Kind, p))
+				}
+			}()
+			f(e)
+		}(f)
+	}
+}
+
+// selfService events are performed by the user themselves unless an actor was given.
+var selfService = map[EventKind]bool{UserCreated: true, UserSignedIn: true, PasswordChanged: true, PasswordResetRequested: true,
+	EmailChangeRequested: true, EmailChanged: true}
+
+// emit runs the before hooks, then op in a transaction which also adds the event to the outbox and records the
+// audit action, then runs the after hooks if op succeeded. op may fill in the ids of e once they are known.
+// Auditors other than AuditLog can't join the transaction, they record the action once it has committed.
+func emit(db *sql.DB, h *Hooks, a Auditor, ob *Outbox, ac AuditContext, e *Event, action string, op func(tx *sql.Tx) error) error {
+	e.ActorId = ac.ActorId
+	if err := h.runBefore(*e); err != nil {
+		return err
+	}
+	ta, inTx := a.(txAuditor)
+	err := Tx(db, func(tx *sql.Tx) error {
+		if err := op(tx); err != nil {
+			return err
+		}
+		if selfService[e.Kind] {
+			ac = ac.self(e.UserId)
+			e.ActorId = ac.ActorId
+		}
+		if inTx {
+			targetType, targetId := e.target()
+			if err := ta.recordTx(tx, ac.event(action, targetType, targetId, e.Changes)); err != nil {
+				return err
+			}
+		}
+		if ob == nil {
+			return nil
+		}
+		return ob.add(tx, *e)
+	})
+	if err != nil {
+		return err
+	}
+	if !inTx {
+		targetType, targetId := e.target()
+		ac.record(a, action, targetType, targetId, e.Changes)
+	}
+	h.runAfter(*e)
+	return nil
+}
+
+// target returns what the audit action of the event is about, the org for org events otherwise the user.
+func (e *Event) target() (string, int64) {
+	if strings.HasPrefix(string(e.Kind), "org.") {
+		return TargetOrg, e.OrgId
+	}
+	return TargetUser, e.UserId
+}
+
+func (us *Users) emit(e *Event, action string, op func(tx *sql.Tx) error) error {
+	return emit(us.db, us.Hooks, us.Auditor, us.Outbox, us.audit, e, action, guardOp(us.guard, op))
+}
+
+func (us *Orgs) emit(e *Event, action string, op func(tx *sql.Tx) error) error {
+	return emit(us.db, us.Hooks, us.Auditor, us.Outbox, us.audit, e, action, guardOp(us.guard, op))
+}
+
+// guardOp returns op preceded by guard, if there is one, in the same transaction.
+func guardOp(guard func(q queryer) error, op func(tx *sql.Tx) error) func(tx *sql.Tx) error {
+	if guard == nil {
+		return op
+	}
+	return func(tx *sql.Tx) error {
+		if err := guard(tx); err != nil {
+			return err
+		}
+		return op(tx)
+	}
+}
+
+func (in *Invites) emit(e *Event, action string, op func(tx *sql.Tx) error) error {
+	return emit(in.db, in.Hooks, in.Auditor, in.Outbox, in.audit, e, action, op)
+}
+
+func (ro *Roles) emit(e *Event, action string, op func(tx *sql.Tx) error) error {
+	return emit(ro.db, ro.Hooks, ro.Auditor, ro.Outbox, ro.audit, e, action, op)
+}
@@ -0,0 +1,51 @@
+package gus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	password := "M0nk3yNutz5"
+	blocked := ErrInvalid("That domain is blocked.")
+	us.Before(func(e Event) error {
+		if strings.HasSuffix(e.User.Email, "@blocked.com") {
+			return blocked
+		}
+		return nil
+	}, UserCreated)
+	created := make(chan Event, 1)
+	us.After(func(e Event) { created <- e }, UserCreated)
+	suspended := make(chan Event, 1)
+	us.After(func(e Event) { suspended <- e }, UserSuspended)
+	defer func() { us.Hooks = NewHooks() }()
+
+	_, _, err := us.SignUp(SignUpParams{Email: "hooks@blocked.com", Password: password})
+	assert.Equal(t, blocked, err)
+	exists, err := us.Exists(ExistsParams{Email: "hooks@blocked.com"})
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	u, _, err := us.SignUp(SignUpParams{Email: "hooks@mail.com", Password: password})
+	assert.Nil(t, err)
+	e := <-created
+	assert.Equal(t, u.Id, e.UserId)
+	assert.Equal(t, u.Id, e.ActorId)
+	assert.Equal(t, "hooks@mail.com", e.User.Email)
+
+	assert.Nil(t, us.By(7, "").Suspend(u.Id))
+	e = <-suspended
+	assert.Equal(t, u.Id, e.UserId)
+	assert.Equal(t, int64(7), e.ActorId)
+	us.Hooks.Wait()
+
+	// Orgs have their own hooks
+	orgsv.Before(func(e Event) error { return ErrInvalid("No deleting.") }, OrgDeleted)
+	defer func() { orgsv.Hooks = NewHooks() }()
+	o, err := orgsv.Create(CreateOrgParams{Name: "Hooked Co."})
+	assert.Nil(t, err)
+	assert.Equal(t, ErrInvalid("No deleting."), orgsv.Delete(o.Id))
+	_, err = orgsv.Get(o.Id)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,190 @@
+package gus
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Export writes everything held about a user to w as a JSON object, for subject access requests. It has the
+// keys "exported" (when, in milliseconds), "user" (including custom attributes), "deleted", "memberships",
+// "invitations", "email_changes" (pending), "sign_ins" and "audit" (every other audit event about or by the
+// user). Events the user made about others leave out their changes and ip, which are about someone else. gus
+// doesn't keep sessions, tokens it issues aren't stored. Reset and confirmation tokens are left out, erased users
+// can't be exported.
+//
+// The audit events are streamed so large histories aren't held in memory. They're read after the rest, outside
+// its transaction, so a slow writer doesn't hold the database, and may include events recorded in between. If w
+// fails part way through what has been written is truncated JSON, the error is returned and it should be discarded.
+func (us *Users) Export(id int64, w io.Writer) error {
+	var u *User
+	var deleted bool
+	var ms []*Membership
+	var invitations []*Invitation
+	var changes []*EmailChange
+	err := Tx(us.db, func(tx *sql.Tx) error {
+		var err error
+		u, err = scanUser(lookupUsers(allUsers).where("u.erased = 0").byId(id).row(tx, userCols, "users u"))
+		if err != nil {
+			return err
+		}
+		if err = tx.QueryRow("SELECT deleted = 1 FROM users WHERE id = ?", id).Scan(&deleted); err != nil {
+			return err
+		}
+		attrs, err := userAttrs.load(tx, us.Attributes, id)
+		if err != nil {
+			return err
+		}
+		u.Attributes = attrs[id]
+		if ms, err = queryMemberships(tx, "m.user_id = ?", id); err != nil {
+			return err
+		}
+		// Deleted users don't hold their email_norm, see releaseKeys.
+		emailKey, err := us.normalizer().Email(u.Email)
+		if err != nil {
+			return err
+		}
+		if invitations, err = exportInvitations(tx, emailKey); err != nil {
+			return err
+		}
+		changes, err = exportEmailChanges(tx, id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	ex := &exporter{w: w, subject: id}
+	ex.field("exported", Milliseconds(time.Now()))
+	ex.field("user", u)
+	ex.field("deleted", deleted)
+	ex.field("memberships", ms)
+	ex.field("invitations", invitations)
+	ex.field("email_changes", changes)
+	signIns := "action IN (?, ?)"
+	ex.auditEvents(us.db, "sign_ins", "target_type = ? AND target_id = ? AND "+signIns,
+		TargetUser, id, AuditSignIn, AuditSignInFailed)
+	ex.auditEvents(us.db, "audit", "((target_type = ? AND target_id = ?) OR actor_id = ?) AND NOT (target_type = ? AND "+signIns+")",
+		TargetUser, id, id, TargetUser, AuditSignIn, AuditSignInFailed)
+	return ex.close()
+}
+
+func exportInvitations(q queryer, emailKey string) ([]*Invitation, error) {
+	rows, err := q.Query("SELECT "+invitationCols+" FROM org_invitations i JOIN orgs o ON i.org_id = o.id "+
+		"WHERE i.email_norm = ? ORDER BY i.created", emailKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*Invitation{}
+	for rows.Next() {
+		i, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+func exportEmailChanges(q queryer, userId int64) ([]*EmailChange, error) {
+	rows, err := q.Query("SELECT id, user_id, COALESCE(old_email, ''), new_email, created FROM email_changes "+
+		"WHERE user_id = ? AND deleted = 0 ORDER BY created", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*EmailChange{}
+	for rows.Next() {
+		var ec EmailChange
+		if err = rows.Scan(&ec.Id, &ec.UserId, &ec.OldEmail, &ec.NewEmail, &ec.Created); err != nil {
+			return nil, err
+		}
+		items = append(items, &ec)
+	}
+	return items, rows.Err()
+}
+
+// exporter writes the fields of a JSON object as they're given, the first error stops any further writing.
+type exporter struct {
+	w       io.Writer
+	err     error
+	fields  int
+	subject int64
+}
+
+func (ex *exporter) write(s string) {
+	if ex.err == nil {
+		_, ex.err = io.WriteString(ex.w, s)
+	}
+}
+
+func (ex *exporter) encode(v interface{}) {
+	if ex.err != nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		ex.err = err
+		return
+	}
+	_, ex.err = ex.w.Write(b)
+}
+
+func (ex *exporter) key(name string) {
+	if ex.fields == 0 {
+		ex.write("{")
+	} else {
+		ex.write(",")
+	}
+	ex.fields++
+	ex.encode(name)
+	ex.write(":")
+}
+
+func (ex *exporter) field(name string, v interface{}) {
+	ex.key(name)
+	ex.encode(v)
+}
+
+// auditEvents writes the audit events matching where as an array, oldest first.
+func (ex *exporter) auditEvents(q queryer, name string, where string, args ...interface{}) {
+	if ex.err != nil {
+		return
+	}
+	rows, err := q.Query("SELECT "+auditCols+" FROM audit_events WHERE "+where+" ORDER BY id", args...)
+	if err != nil {
+		ex.err = err
+		return
+	}
+	defer rows.Close()
+	ex.key(name)
+	ex.write("[")
+	for i := 0; ex.err == nil && rows.Next(); i++ {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			ex.err = err
+			return
+		}
+		if e.TargetType != TargetUser || e.TargetId != ex.subject {
+			e.Changes, e.Ip = nil, ""
+		}
+		if i > 0 {
+			ex.write(",")
+		}
+		ex.encode(e)
+	}
+	ex.write("]")
+	if ex.err == nil {
+		ex.err = rows.Err()
+	}
+}
+
+func (ex *exporter) close() error {
+	if ex.fields == 0 {
+		ex.write("{")
+	}
+	ex.write("}")
+	return ex.err
+}
@@ -0,0 +1,121 @@
+package gus
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	password := "M0nk3yNutz5"
+	al := NewAuditLog(us.db)
+	ua := us.By(0, "10.0.0.7")
+	ua.Auditor = al
+	o, err := orgsv.Create(CreateOrgParams{Name: "Exported"})
+	assert.Nil(t, err)
+	u, _, err := ua.SignUp(SignUpParams{Email: "export.me@mail.com", FirstName: "Export", Password: password, OrgId: o.Id})
+	assert.Nil(t, err)
+	_, err = ua.SignIn(SignInParams{Email: "export.me@mail.com", Password: password})
+	assert.Nil(t, err)
+	_, err = ua.SignIn(SignInParams{Email: "export.me@mail.com", Password: "wrong"})
+	assert.Equal(t, ErrNotAuth, err)
+	name := "Exporter"
+	assert.Nil(t, ua.Update(UpdateUserParams{Id: &u.Id, LastName: &name}))
+	_, err = ua.RequestEmailChange(u.Id, "export.me2@mail.com")
+	assert.Nil(t, err)
+
+	var b strings.Builder
+	assert.Nil(t, ua.Export(u.Id, &b))
+	var bundle struct {
+		Exported     int64          `json:"exported"`
+		User         User           `json:"user"`
+		Deleted      bool           `json:"deleted"`
+		Memberships  []*Membership  `json:"memberships"`
+		Invitations  []*Invitation  `json:"invitations"`
+		EmailChanges []*EmailChange `json:"email_changes"`
+		SignIns      []*AuditEvent  `json:"sign_ins"`
+		Audit        []*AuditEvent  `json:"audit"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(b.String()), &bundle))
+	assert.True(t, bundle.Exported > 0)
+	assert.Equal(t, "Exporter", bundle.User.LastName)
+	assert.False(t, bundle.Deleted)
+	if assert.Equal(t, 1, len(bundle.Memberships)) {
+		assert.Equal(t, o.Id, bundle.Memberships[0].OrgId)
+	}
+	if assert.Equal(t, 1, len(bundle.EmailChanges)) {
+		assert.Equal(t, "export.me2@mail.com", bundle.EmailChanges[0].NewEmail)
+	}
+	assert.NotContains(t, b.String(), "token")
+	if assert.Equal(t, 2, len(bundle.SignIns)) {
+		assert.Equal(t, AuditSignIn, bundle.SignIns[0].Action)
+		assert.Equal(t, AuditSignInFailed, bundle.SignIns[1].Action)
+	}
+	actions := []string{}
+	for _, e := range bundle.Audit {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{AuditSignUp, AuditUserUpdate, AuditEmailRequest}, actions)
+
+	// Deleted users are exported, erased users have nothing left to export.
+	assert.Nil(t, us.Delete(u.Id))
+	b.Reset()
+	assert.Nil(t, us.Export(u.Id, &b))
+	assert.Contains(t, b.String(), `"deleted":true`)
+	assert.Nil(t, us.Erase(u.Id))
+	assert.Equal(t, ErrNotFound, us.Export(u.Id, &b))
+}
+
+// limitedWriter fails once n bytes have been written.
+type limitedWriter struct {
+	b strings.Builder
+	n int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.b.Len()+len(p) > w.n {
+		return 0, errors.New("connection reset")
+	}
+	return w.b.Write(p)
+}
+
+func TestExport_WriterFails(t *testing.T) {
+	u, _, err := us.SignUp(SignUpParams{Email: "export.fails@mail.com", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+	w := &limitedWriter{n: 64}
+	assert.EqualError(t, us.Export(u.Id, w), "connection reset")
+	var v map[string]interface{}
+	assert.NotNil(t, json.Unmarshal([]byte(w.b.String()), &v))
+}
+
+func TestExport_OthersEvents(t *testing.T) {
+	password := "M0nk3yNutz5"
+	al := NewAuditLog(us.db)
+	admin, _, err := us.SignUp(SignUpParams{Email: "export.admin@mail.com", Password: password})
+	assert.Nil(t, err)
+	other, _, err := us.SignUp(SignUpParams{Email: "export.other@mail.com", LastName: "Other", Password: password})
+	assert.Nil(t, err)
+	ua := us.By(admin.Id, "10.0.0.8")
+	ua.Auditor = al
+	name, secret := "Exported", "Other's new name"
+	assert.Nil(t, ua.Update(UpdateUserParams{Id: &admin.Id, LastName: &name}))
+	assert.Nil(t, ua.Update(UpdateUserParams{Id: &other.Id, LastName: &secret}))
+
+	var b strings.Builder
+	assert.Nil(t, us.Export(admin.Id, &b))
+	var bundle struct {
+		Audit []*AuditEvent `json:"audit"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(b.String()), &bundle))
+	assert.NotContains(t, b.String(), secret)
+	if assert.Equal(t, 2, len(bundle.Audit)) {
+		assert.Equal(t, admin.Id, bundle.Audit[0].TargetId)
+		assert.Equal(t, "10.0.0.8", bundle.Audit[0].Ip)
+		assert.Equal(t, Change{From: "", To: name}, bundle.Audit[0].Changes["last_name"])
+		assert.Equal(t, other.Id, bundle.Audit[1].TargetId)
+		assert.Equal(t, "", bundle.Audit[1].Ip)
+		assert.Nil(t, bundle.Audit[1].Changes)
+	}
+}
@@ -0,0 +1,189 @@
+package gus
+
+import (
+	"fmt"
+	"strings"
+)
+
+type FilterOp string
+
+const (
+	OpEq     FilterOp = "eq"
+	OpNe     FilterOp = "ne"
+	OpIn     FilterOp = "in"
+	OpPrefix FilterOp = "prefix"
+	OpRange  FilterOp = "range"   // From inclusive, To exclusive, either may be omitted.
+	OpIsNull FilterOp = "is_null" // Value false matches values which aren't null.
+	OpAnd    FilterOp = "and"
+	OpOr     FilterOp = "or"
+)
+
+// maxFilterDepth limits the nesting of and/or groups.
+const maxFilterDepth = 8
+
+// FilterFields maps the field names clients may filter by to sql columns.
+type FilterFields map[string]string
+
+var (
+	UserFilterFields = FilterFields{
+		"id": "u.id", "username": "u.username", "email": "u.email", "first_name": "u.first_name",
+		"last_name": "u.last_name", "phone": "u.phone", "org_id": "u.org_id", "role": "u.role",
+		"suspended": "u.suspended", "passive": "u.passive", "activated": "u.activated", "created": "u.created",
+		"updated": "u.updated",
+	}
+	OrgFilterFields = FilterFields{
+		"id": "id", "parent_id": "COALESCE(parent_id, 0)", "name": "name", "type": "type", "street": "street",
+		"suburb": "suburb", "town": "town", "postcode": "postcode", "country": "country", "suspended": "suspended", "created": "created",
+		"updated": "updated",
+	}
+)
+
+// Filter is a condition on a field or an and/or group of filters, it can be built with Eq, In, And etc. or
+// decoded from json e.g. {"op": "and", "filters": [{"op": "eq", "field": "suspended", "value": true}, ...]}.
+type Filter struct {
+	Op      FilterOp      `json:"op"`
+	Field   string        `json:"field,omitempty"`
+	Value   interface{}   `json:"value,omitempty"`
+	Values  []interface{} `json:"values,omitempty"`
+	From    interface{}   `json:"from,omitempty"`
+	To      interface{}   `json:"to,omitempty"`
+	Filters []*Filter     `json:"filters,omitempty"`
+}
+
+func Eq(field string, value interface{}) *Filter {
+	return &Filter{Op: OpEq, Field: field, Value: value}
+}
+
+func Ne(field string, value interface{}) *Filter {
+	return &Filter{Op: OpNe, Field: field, Value: value}
+}
+
+func In(field string, values ...interface{}) *Filter {
+	return &Filter{Op: OpIn, Field: field, Values: values}
+}
+
+func Prefix(field string, prefix string) *Filter {
+	return &Filter{Op: OpPrefix, Field: field, Value: prefix}
+}
+
+// Range matches from <= field < to, pass nil to leave either end open.
+func Range(field string, from interface{}, to interface{}) *Filter {
+	return &Filter{Op: OpRange, Field: field, From: from, To: to}
+}
+
+func IsNull(field string) *Filter {
+	return &Filter{Op: OpIsNull, Field: field, Value: true}
+}
+
+func NotNull(field string) *Filter {
+	return &Filter{Op: OpIsNull, Field: field, Value: false}
+}
+
+func And(filters ...*Filter) *Filter {
+	return &Filter{Op: OpAnd, Filters: filters}
+}
+
+func Or(filters ...*Filter) *Filter {
+	return &Filter{Op: OpOr, Filters: filters}
+}
+
+// Sql returns a parameterised condition for the filter, fields not in fields are rejected.
+func (f *Filter) Sql(fields FilterFields) (string, []interface{}, error) {
+	return f.sql(fields, 0)
+}
+
+func (f *Filter) sql(fields FilterFields, depth int) (string, []interface{}, error) {
+	if f.Op == OpAnd || f.Op == OpOr {
+		if depth >= maxFilterDepth {
+			return "", nil, ErrInvalid("Filters are nested too deeply.")
+		}
+		parts := []string{}
+		args := []interface{}{}
+		for _, c := range f.Filters {
+			if c == nil {
+				continue
+			}
+			s, a, err := c.sql(fields, depth+1)
+			if err != nil {
+				return "", nil, err
+			}
+			parts = append(parts, s)
+			args = append(args, a...)
+		}
+		if len(parts) == 0 {
+			return "", nil, ErrInvalid(fmt.Sprintf("'%s' needs at least one filter.", f.Op))
+		}
+		return "(" + strings.Join(parts, " "+strings.ToUpper(string(f.Op))+" ") + ")", args, nil
+	}
+	col, ok := fields[f.Field]
+	if !ok {
+		return "", nil, ErrInvalid(fmt.Sprintf("Can't filter by '%s', filterable fields are: %s.", f.Field, SortFields(fields).names()))
+	}
+	switch f.Op {
+	case OpEq:
+		return col + " = ?", []interface{}{filterValue(f.Value)}, nil
+	case OpNe:
+		return col + " <> ?", []interface{}{filterValue(f.Value)}, nil
+	case OpIn:
+		if len(f.Values) == 0 {
+			return "1 = 0", nil, nil
+		}
+		args := make([]interface{}, len(f.Values))
+		for i, v := range f.Values {
+			args[i] = filterValue(v)
+		}
+		return col + " IN (?" + strings.Repeat(", ?", len(args)-1) + ")", args, nil
+	case OpPrefix:
+		s, ok := f.Value.(string)
+		if !ok {
+			return "", nil, ErrInvalid(fmt.Sprintf("'prefix' of '%s' must be a string.", f.Field))
+		}
+		return col + " LIKE ? ESCAPE '!'", []interface{}{likeEscaper.Replace(s) + "%"}, nil
+	case OpRange:
+		parts := []string{}
+		args := []interface{}{}
+		if f.From != nil {
+			parts = append(parts, col+" >= ?")
+			args = append(args, filterValue(f.From))
+		}
+		if f.To != nil {
+			parts = append(parts, col+" < ?")
+			args = append(args, filterValue(f.To))
+		}
+		if len(parts) == 0 {
+			return "", nil, ErrInvalid(fmt.Sprintf("'range' of '%s' needs 'from' or 'to'.", f.Field))
+		}
+		return "(" + strings.Join(parts, " AND ") + ")", args, nil
+	case OpIsNull:
+		if b, ok := f.Value.(bool); ok && !b {
+			return col + " IS NOT NULL", nil, nil
+		}
+		return col + " IS NULL", nil, nil
+	}
+	return "", nil, ErrInvalid(fmt.Sprintf("Unknown filter op '%s'.", f.Op))
+}
+
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// filterValue stores bools the way the flag columns do.
+func filterValue(v interface{}) interface{} {
+	if b, ok := v.(bool); ok {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	return v
+}
+
+// addFilter adds the filter to both the list and count queries.
+func addFilter(sqla string, sqlb string, params []interface{}, f *Filter, fields FilterFields) (string, string, []interface{}, error) {
+	if f == nil {
+		return sqla, sqlb, params, nil
+	}
+	s, args, err := f.Sql(fields)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return sqla + " AND " + s, sqlb + " AND " + s, append(params, args...), nil
+}
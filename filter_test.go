@@ -0,0 +1,73 @@
+package gus
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestUsers_ListFilter(t *testing.T) {
+	a, err := orgsv.Create(CreateOrgParams{Name: "Filter A"})
+	assert.Nil(t, err)
+	b, err := orgsv.Create(CreateOrgParams{Name: "Filter B"})
+	assert.Nil(t, err)
+	c, err := orgsv.Create(CreateOrgParams{Name: "Filter C"})
+	assert.Nil(t, err)
+	ids := map[string]int64{}
+	for _, n := range []struct {
+		name string
+		org  int64
+	}{{"fa1", a.Id}, {"fa2", a.Id}, {"fb1", b.Id}, {"fc1", c.Id}} {
+		u, _, err := us.SignUp(SignUpParams{Email: n.name + "-filter@mail.com", FirstName: n.name, Password: "M0nk3yNutz5", OrgId: n.org})
+		assert.Nil(t, err)
+		ids[n.name] = u.Id
+	}
+	for _, n := range []string{"fa1", "fb1", "fc1"} {
+		assert.Nil(t, us.Suspend(ids[n]))
+	}
+
+	// Suspended users created in the last week in org a or b.
+	weekAgo := Milliseconds(time.Now().AddDate(0, 0, -7))
+	where := And(Eq("suspended", true), Range("created", weekAgo, nil), In("org_id", a.Id, b.Id))
+	res, err := us.List(ListUsersParams{UserFilters: UserFilters{Where: where}, ListArgs: ListArgs{OrderBy: "first_name", Direction: DirectionAsc}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), res.Total)
+	if assert.Equal(t, 2, len(res.Items)) {
+		assert.Equal(t, "fa1", res.Items[0].FirstName)
+		assert.Equal(t, "fb1", res.Items[1].FirstName)
+	}
+
+	where = Or(And(Eq("org_id", a.Id), Ne("suspended", true)), Eq("org_id", c.Id))
+	res, err = us.List(ListUsersParams{UserFilters: UserFilters{Where: where}, ListArgs: ListArgs{OrderBy: "first_name", Direction: DirectionAsc}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), res.Total)
+	if assert.Equal(t, 2, len(res.Items)) {
+		assert.Equal(t, "fa2", res.Items[0].FirstName)
+		assert.Equal(t, "fc1", res.Items[1].FirstName)
+	}
+
+	// Wildcards in prefixes are literal.
+	res, err = us.List(ListUsersParams{UserFilters: UserFilters{Where: Prefix("email", "f_")}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), res.Total)
+	res, err = us.List(ListUsersParams{UserFilters: UserFilters{Where: And(Prefix("email", "fa"), Range("created", nil, weekAgo))}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), res.Total)
+
+	// Filters can be decoded from json.
+	var f Filter
+	assert.Nil(t, json.Unmarshal([]byte(`{"op": "and", "filters": [{"op": "in", "field": "id", "values": [`+fmt.Sprint(a.Id)+`]}, {"op": "eq", "field": "parent_id", "value": 0}]}`), &f))
+	orgs, err := orgsv.List(ListOrgsParams{OrgFilters: OrgFilters{Where: &f}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), orgs.Total)
+
+	_, err = us.List(ListUsersParams{UserFilters: UserFilters{Where: Eq("password_hash", "x")}})
+	assert.IsType(t, &ValidationError{}, err)
+	assert.Contains(t, err.Error(), "Can't filter by 'password_hash'")
+	_, err = us.List(ListUsersParams{UserFilters: UserFilters{Where: Or()}})
+	assert.IsType(t, &ValidationError{}, err)
+	_, err = us.List(ListUsersParams{UserFilters: UserFilters{Where: Range("created", nil, nil)}})
+	assert.IsType(t, &ValidationError{}, err)
+}
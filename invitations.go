@@ -0,0 +1,178 @@
+package gus
+
+import (
+	"database/sql"
+	"github.com/asaskevich/govalidator"
+	"time"
+)
+
+var (
+	ErrAlreadyInvited      = ErrInvalid("That user has already been invited.")
+	ErrAlreadyMember       = ErrInvalid("That user is already a member.")
+	ErrInvitationNotForYou = ErrInvalid("That invitation was sent to someone else.")
+)
+
+type InvitationStatus string
+
+const (
+	InvitationPending  InvitationStatus = "pending"
+	InvitationAccepted InvitationStatus = "accepted"
+	InvitationDeclined InvitationStatus = "declined"
+)
+
+// Invitation asks an existing user to join an org with a given role.
+type Invitation struct {
+	Id      int64            `json:"id"`
+	OrgId   int64            `json:"org_id"`
+	OrgName string           `json:"org_name"`
+	Email   string           `json:"email"`
+	Role    Role             `json:"role"`
+	Status  InvitationStatus `json:"status"`
+	Created int64            `json:"created"`
+	Updated int64            `json:"updated"`
+}
+
+// InviteMember creates a pending invitation for the user with the email to join the org.
+func (us *Orgs) InviteMember(orgId int64, email string, role Role) (*Invitation, error) {
+	email = us.normalizer().Clean(email)
+	if !govalidator.IsEmail(email) {
+		return nil, ErrEmailInvalid
+	}
+	emailKey, err := us.normalizer().Email(email)
+	if err != nil {
+		return nil, err
+	}
+	o, err := us.Get(orgId)
+	if err != nil {
+		return nil, err
+	}
+	i := &Invitation{OrgId: o.Id, OrgName: o.Name, Email: email, Role: role, Status: InvitationPending,
+		Created: Milliseconds(time.Now()), Updated: Milliseconds(time.Now())}
+	e := &Event{Kind: MemberInvited, OrgId: o.Id, Changes: map[string]Change{"email": {To: email}, "role": {To: role}}}
+	err = us.emit(e, AuditMemberInvite, func(tx *sql.Tx) error {
+		if err := checkRole(tx, role, orgId); err != nil {
+			return err
+		}
+		var userId int64
+		err := CheckNotFound(lookupUsers(liveUsers).where("u.email_norm = ?", emailKey).row(tx, "u.id, u.email", "users u").Scan(&userId, &i.Email))
+		if err != nil {
+			return err
+		}
+		e.UserId = userId
+		var count int64
+		err = tx.QueryRow("SELECT count(user_id) FROM memberships WHERE user_id = ? AND org_id = ?", userId, orgId).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrAlreadyMember
+		}
+		err = tx.QueryRow("SELECT count(id) FROM org_invitations WHERE org_id = ? AND email_norm = ? AND status = ?",
+			orgId, emailKey, InvitationPending).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrAlreadyInvited
+		}
+		res, err := tx.Exec("INSERT INTO org_invitations (org_id, email, email_norm, role, status, created, updated) values (?, ?, ?, ?, ?, ?, ?)",
+			i.OrgId, i.Email, emailKey, i.Role, i.Status, i.Created, i.Updated)
+		if err != nil {
+			return err
+		}
+		i.Id, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// AcceptInvitation adds a membership of the invitation's org with the invitation's role.
+func (us *Orgs) AcceptInvitation(invitationId int64, userId int64) error {
+	return us.respond(invitationId, userId, InvitationAccepted)
+}
+
+func (us *Orgs) DeclineInvitation(invitationId int64, userId int64) error {
+	return us.respond(invitationId, userId, InvitationDeclined)
+}
+
+// respond accepts or declines the invitation, the user responds for themselves unless an actor was given.
+func (us *Orgs) respond(invitationId int64, userId int64, status InvitationStatus) error {
+	if us.audit.ActorId == 0 {
+		us = us.By(userId, us.audit.Ip)
+	}
+	e := &Event{Kind: InvitationRefused, UserId: userId, Changes: map[string]Change{"invitation_id": {To: invitationId}}}
+	action := AuditInviteDecline
+	if status == InvitationAccepted {
+		e.Kind, action = MemberAdded, AuditInviteAccept
+	}
+	return us.emit(e, action, func(tx *sql.Tx) error {
+		i, err := scanInvitation(tx.QueryRow("SELECT "+invitationCols+" FROM org_invitations i JOIN orgs o ON i.org_id = o.id "+
+			"WHERE i.id = ? AND i.status = ? AND o.deleted = 0 LIMIT 1", invitationId, InvitationPending))
+		if err != nil {
+			return err
+		}
+		e.OrgId = i.OrgId
+		var userKey, invitedKey sql.NullString
+		err = CheckNotFound(lookupUsers(liveUsers).byId(userId).row(tx, "u.email_norm", "users u").Scan(&userKey))
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRow("SELECT email_norm FROM org_invitations WHERE id = ?", i.Id).Scan(&invitedKey)
+		if err != nil {
+			return err
+		}
+		if !userKey.Valid || userKey.String != invitedKey.String {
+			return ErrInvitationNotForYou
+		}
+		if status == InvitationAccepted {
+			e.Changes["user_id"], e.Changes["role"] = Change{To: userId}, Change{To: i.Role}
+			err = addMember(tx, i.OrgId, userId, i.Role)
+			if err != nil {
+				return err
+			}
+		}
+		return CheckUpdated(tx.Exec("UPDATE org_invitations SET status = ?, updated = ? WHERE id = ?", status, Milliseconds(time.Now()), i.Id))
+	})
+}
+
+// PendingInvitations lists the invitations of an org which haven't been accepted or declined.
+func (us *Orgs) PendingInvitations(orgId int64) ([]*Invitation, error) {
+	return us.invitations("i.org_id = ?", orgId)
+}
+
+// UserInvitations lists the pending invitations sent to a user.
+func (us *Orgs) UserInvitations(userId int64) ([]*Invitation, error) {
+	return us.invitations("i.email_norm = (SELECT email_norm FROM users WHERE id = ?)", userId)
+}
+
+const invitationCols = "i.id, i.org_id, o.name, i.email, i.role, i.status, i.created, i.updated"
+
+func (us *Orgs) invitations(where string, arg interface{}) ([]*Invitation, error) {
+	rows, err := us.db.Query("SELECT "+invitationCols+" FROM org_invitations i JOIN orgs o ON i.org_id = o.id "+
+		"WHERE "+where+" AND i.status = ? AND o.deleted = 0 ORDER BY i.created DESC", arg, InvitationPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*Invitation{}
+	for rows.Next() {
+		i, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+func scanInvitation(row scanner) (*Invitation, error) {
+	var i Invitation
+	err := CheckNotFound(row.Scan(&i.Id, &i.OrgId, &i.OrgName, &i.Email, &i.Role, &i.Status, &i.Created, &i.Updated))
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
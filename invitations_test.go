@@ -0,0 +1,91 @@
+package gus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestOrgs_InviteMember(t *testing.T) {
+	o, err := orgsv.Create(CreateOrgParams{Name: "Inviters Inc."})
+	assert.Nil(t, err)
+	u, _, err := us.SignUp(SignUpParams{Email: "invitee@mail.com", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+	other, _, err := us.SignUp(SignUpParams{Email: "not-invitee@mail.com", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+
+	// No such user
+	_, err = orgsv.InviteMember(o.Id, "nobody@mail.com", 3)
+	assert.Equal(t, ErrNotFound, err)
+
+	i, err := orgsv.InviteMember(o.Id, u.Email, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, InvitationPending, i.Status)
+	_, err = orgsv.InviteMember(o.Id, u.Email, 3)
+	assert.Equal(t, ErrAlreadyInvited, err)
+
+	pending, err := orgsv.PendingInvitations(o.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(pending))
+	pending, err = orgsv.UserInvitations(u.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(pending))
+	assert.Equal(t, o.Name, pending[0].OrgName)
+
+	// Only the invitee can accept
+	err = orgsv.AcceptInvitation(i.Id, other.Id)
+	assert.Equal(t, ErrInvitationNotForYou, err)
+
+	err = orgsv.AcceptInvitation(i.Id, u.Id)
+	assert.Nil(t, err)
+	u, err = us.Get(u.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, o.Id, u.OrgId)
+	assert.Equal(t, Role(3), u.Role)
+	pending, err = orgsv.PendingInvitations(o.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(pending))
+	_, err = orgsv.InviteMember(o.Id, u.Email, 3)
+	assert.Equal(t, ErrAlreadyMember, err)
+
+	// Decline
+	i, err = orgsv.InviteMember(o.Id, other.Email, 1)
+	assert.Nil(t, err)
+	err = orgsv.DeclineInvitation(i.Id, other.Id)
+	assert.Nil(t, err)
+	other, err = us.Get(other.Id)
+	assert.Nil(t, err)
+	assert.NotEqual(t, o.Id, other.OrgId)
+	err = orgsv.AcceptInvitation(i.Id, other.Id)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestOrgs_InviteMember_Audited(t *testing.T) {
+	al := NewAuditLog(us.db)
+	oa := orgsv.By(0, "10.0.0.3")
+	oa.Auditor = al
+	o, err := oa.Create(CreateOrgParams{Name: "Invites Audited Inc."})
+	assert.Nil(t, err)
+	u, _, err := us.SignUp(SignUpParams{Email: "Case.Invitee@mail.com", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+
+	_, err = oa.InviteMember(o.Id, u.Email, 1<<20)
+	assert.Equal(t, ErrRoleNotFound, err)
+	i, err := oa.InviteMember(o.Id, " case.invitee@MAIL.com", RoleAdmin)
+	assert.Nil(t, err)
+	pending, err := oa.UserInvitations(u.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(pending))
+	assert.Nil(t, oa.AcceptInvitation(i.Id, u.Id))
+
+	res, err := al.List(ListAuditParams{AuditFilters: AuditFilters{TargetType: TargetOrg, TargetId: o.Id}, ListArgs: ListArgs{Direction: DirectionAsc}})
+	assert.Nil(t, err)
+	actions := []string{}
+	for _, e := range res.Items {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{AuditOrgCreate, AuditMemberInvite, AuditInviteAccept}, actions)
+	if len(res.Items) == 3 {
+		assert.Equal(t, u.Id, res.Items[2].ActorId)
+		assert.Equal(t, Change{To: float64(u.Id)}, res.Items[2].Changes["user_id"])
+	}
+}
@@ -0,0 +1,272 @@
+package gus
+
+import (
+	"database/sql"
+	"time"
+)
+
+var (
+	ErrInviteRequired    = ErrInvalid("An 'invite_code' is required.")
+	ErrInvalidInviteCode = ErrInvalid("Invalid invite code.")
+	ErrInviteExpired     = ErrInvalid("That invite has expired.")
+	ErrInviteUsedUp      = ErrInvalid("That invite has already been used.")
+)
+
+type InviteOpts struct {
+	PassGen    PasswordGen // A function used to generate invite codes.
+	CodeLength int64       // Length of generated codes, max 30.
+	Auditor    Auditor     // Optional, records invites being created and revoked.
+	Outbox     *Outbox     // Optional, events are added in the same transaction as the change for webhook delivery.
+}
+
+func NewInvites(db *sql.DB, opt InviteOpts) *Invites {
+	if opt.PassGen == nil {
+		opt.PassGen = SecureRandString
+	}
+	if opt.CodeLength < 1 || opt.CodeLength > 30 {
+		opt.CodeLength = 12
+	}
+	return &Invites{db: db, InviteOpts: opt, Hooks: NewHooks()}
+}
+
+// Invites are codes which allow users to sign up to an org with a given role.
+type Invites struct {
+	db *sql.DB
+	InviteOpts
+	*Hooks
+	audit AuditContext
+}
+
+type Invite struct {
+	Id      int64  `json:"id"`
+	Code    string `json:"code"`
+	OrgId   int64  `json:"org_id"`
+	Role    Role   `json:"role"`
+	MaxUses int64  `json:"max_uses"` // 0 is unlimited.
+	Uses    int64  `json:"uses"`
+	Expires int64  `json:"expires"` // Milliseconds, 0 never expires.
+	Created int64  `json:"created"`
+	Updated int64  `json:"updated"`
+	Revoked bool   `json:"revoked"`
+}
+
+type InviteRedemption struct {
+	InviteId int64 `json:"invite_id"`
+	UserId   int64 `json:"user_id"`
+	Created  int64 `json:"created"`
+}
+
+type CreateInviteParams struct {
+	OrgId           int64 `json:"org_id"`
+	Role            Role  `json:"role"`
+	MaxUses         int64 `json:"max_uses"`
+	Expires         int64 `json:"expires"`
+	CustomValidator `json:"-"`
+}
+
+func (va *CreateInviteParams) Validate() error {
+	if va.CustomValidator != nil {
+		return va.CustomValidator()
+	}
+	if va.MaxUses < 0 {
+		return ErrInvalid("'max_uses' must be 0 (unlimited) or more.")
+	}
+	if va.Expires != 0 && va.Expires < Milliseconds(time.Now()) {
+		return ErrInvalid("'expires' must be in the future.")
+	}
+	return nil
+}
+
+// Create makes an invite to an existing org with a role which can be used in it.
+func (in *Invites) Create(p CreateInviteParams) (*Invite, error) {
+	i := &Invite{Code: in.PassGen(in.CodeLength), OrgId: p.OrgId, Role: p.Role, MaxUses: p.MaxUses, Expires: p.Expires,
+		Created: Milliseconds(time.Now()), Updated: Milliseconds(time.Now())}
+	e := &Event{Kind: InviteCreated, OrgId: p.OrgId, Changes: map[string]Change{"role": {To: p.Role}, "max_uses": {To: p.MaxUses},
+		"expires": {To: p.Expires}}}
+	err := in.emit(e, AuditInviteCreate, func(tx *sql.Tx) error {
+		var count int64
+		err := tx.QueryRow("SELECT count(id) FROM orgs WHERE id = ? AND deleted = 0", p.OrgId).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrOrgInvalid
+		}
+		if err = checkRole(tx, p.Role, p.OrgId); err != nil {
+			return err
+		}
+		res, err := tx.Exec("INSERT INTO invites(code, org_id, role, max_uses, uses, expires, created, updated, deleted) values(?,?,?,?,?,?,?,?,?)",
+			i.Code, i.OrgId, i.Role, i.MaxUses, 0, i.Expires, i.Created, i.Updated, 0)
+		if err != nil {
+			return err
+		}
+		i.Id, err = res.LastInsertId()
+		e.Changes["invite_id"] = Change{To: i.Id}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+const inviteCols = "id, code, org_id, role, max_uses, uses, expires, created, updated, deleted"
+
+func (in *Invites) Get(id int64) (*Invite, error) {
+	return scanInvite(in.db.QueryRow("SELECT "+inviteCols+" FROM invites WHERE id = ? LIMIT 1", id))
+}
+
+func (in *Invites) GetByCode(code string) (*Invite, error) {
+	return scanInvite(in.db.QueryRow("SELECT "+inviteCols+" FROM invites WHERE code = ? LIMIT 1", code))
+}
+
+// Revoke prevents any further use of the invite.
+func (in *Invites) Revoke(id int64) error {
+	e := &Event{Kind: InviteRevoked, Changes: map[string]Change{"invite_id": {From: id}}}
+	return in.emit(e, AuditInviteRevoke, func(tx *sql.Tx) error {
+		err := CheckNotFound(tx.QueryRow("SELECT org_id FROM invites WHERE id = ? AND deleted = 0", id).Scan(&e.OrgId))
+		if err != nil {
+			return err
+		}
+		return CheckUpdated(tx.Exec("UPDATE invites SET deleted = 1, updated = ? WHERE id = ? AND deleted = 0", Milliseconds(time.Now()), id))
+	})
+}
+
+// Redemptions lists the users who signed up with the invite.
+func (in *Invites) Redemptions(id int64) ([]*InviteRedemption, error) {
+	rows, err := in.db.Query("SELECT invite_id, user_id, created FROM invite_redemptions WHERE invite_id = ? ORDER BY created", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rs := []*InviteRedemption{}
+	for rows.Next() {
+		r := &InviteRedemption{}
+		if err = rows.Scan(&r.InviteId, &r.UserId, &r.Created); err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+	return rs, rows.Err()
+}
+
+type ListInvitesParams struct {
+	ListArgs
+	CustomValidator `json:"-"`
+	InviteFilters
+}
+
+type InviteFilters struct {
+	OrgId int64 `schema:"org_id"`
+	Role  int64 `schema:"role"`
+}
+
+func (va *ListInvitesParams) Validate() error {
+	if va.CustomValidator != nil {
+		return va.CustomValidator()
+	}
+	return nil
+}
+
+type InviteListResponse struct {
+	ListArgs
+	Total int64     `json:"total"`
+	Items []*Invite `json:"items"`
+}
+
+// List returns invites, revoked invites are only included when Deleted is true.
+func (in *Invites) List(p ListInvitesParams) (*InviteListResponse, error) {
+	q := "SELECT " + inviteCols + " FROM invites WHERE 1"
+	countq := "SELECT count(id) FROM invites WHERE 1"
+
+	args := []interface{}{}
+	if !p.Deleted {
+		q += " AND deleted = 0"
+		countq += " AND deleted = 0"
+	}
+	if p.OrgId > 0 {
+		q, countq, args = addClause(q, countq, " AND org_id = ?", args, p.OrgId)
+	}
+	if p.Role > 0 {
+		q, countq, args = addClause(q, countq, " AND role = ?", args, p.Role)
+	}
+	rows, err := GetRows(in.db, q, InviteSortFields, &p.ListArgs, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var total int64
+	err = in.db.QueryRow(countq, args...).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+	items := []*Invite{}
+	for rows.Next() {
+		i, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return &InviteListResponse{
+		Total: total,
+		Items: items,
+		ListArgs: ListArgs{
+			Size:      p.Size,
+			Page:      p.Page,
+			Direction: p.Direction,
+			OrderBy:   p.OrderBy,
+			Deleted:   p.Deleted,
+		}}, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanInvite(row scanner) (*Invite, error) {
+	var i Invite
+	var deleted int
+	err := CheckNotFound(row.Scan(&i.Id, &i.Code, &i.OrgId, &i.Role, &i.MaxUses, &i.Uses, &i.Expires, &i.Created, &i.Updated, &deleted))
+	if err != nil {
+		return nil, err
+	}
+	i.Revoked = deleted > 0
+	return &i, nil
+}
+
+// checkInvite returns the invite for the code if it can still be used.
+func checkInvite(tx *sql.Tx, code string) (*Invite, error) {
+	i, err := scanInvite(tx.QueryRow("SELECT "+inviteCols+" FROM invites WHERE code = ? AND deleted = 0 LIMIT 1", code))
+	if err != nil {
+		if _, ok := err.(*NotFoundError); ok {
+			return nil, ErrInvalidInviteCode
+		}
+		return nil, err
+	}
+	if i.Expires > 0 && Milliseconds(time.Now()) > i.Expires {
+		return nil, ErrInviteExpired
+	}
+	if i.MaxUses > 0 && i.Uses >= i.MaxUses {
+		return nil, ErrInviteUsedUp
+	}
+	return i, nil
+}
+
+// redeemInvite records the use of an invite, the uses are re-checked in the update so concurrent sign ups can't
+// exceed MaxUses.
+func redeemInvite(tx *sql.Tx, i *Invite, userId int64) error {
+	res, err := tx.Exec("UPDATE invites SET uses = uses + 1, updated = ? WHERE id = ? AND deleted = 0 AND (max_uses = 0 OR uses < max_uses)",
+		Milliseconds(time.Now()), i.Id)
+	if err = CheckUpdated(res, err); err != nil {
+		if _, ok := err.(*NotFoundError); ok {
+			return ErrInviteUsedUp
+		}
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO invite_redemptions (invite_id, user_id, created) values (?, ?, ?)", i.Id, userId, Milliseconds(time.Now()))
+	return err
+}
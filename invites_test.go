@@ -0,0 +1,70 @@
+package gus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestInvites_SignUp(t *testing.T) {
+	o, err := orgsv.Create(CreateOrgParams{Name: "Invites Inc."})
+	assert.Nil(t, err)
+	i, err := invs.Create(CreateInviteParams{OrgId: o.Id, Role: RoleAdmin, MaxUses: 2})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, i.Code)
+
+	u, _, err := us.SignUp(SignUpParams{Email: "invited1@mail.com", InviteCode: i.Code, OrgId: 9, Role: 99})
+	assert.Nil(t, err)
+	assert.Equal(t, o.Id, u.OrgId)
+	assert.Equal(t, RoleAdmin, u.Role)
+	_, _, err = us.SignUp(SignUpParams{Email: "invited2@mail.com", InviteCode: i.Code})
+	assert.Nil(t, err)
+
+	// Used up
+	_, _, err = us.SignUp(SignUpParams{Email: "invited3@mail.com", InviteCode: i.Code})
+	assert.Equal(t, ErrInviteUsedUp, err)
+	i, err = invs.Get(i.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), i.Uses)
+	rs, err := invs.Redemptions(i.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rs))
+	assert.Equal(t, u.Id, rs[0].UserId)
+
+	// Unknown
+	_, _, err = us.SignUp(SignUpParams{Email: "invited4@mail.com", InviteCode: "nope"})
+	assert.Equal(t, ErrInvalidInviteCode, err)
+
+	// Expired
+	i, err = invs.Create(CreateInviteParams{OrgId: o.Id, Expires: Milliseconds(time.Now()) + 50})
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+	_, _, err = us.SignUp(SignUpParams{Email: "invited5@mail.com", InviteCode: i.Code})
+	assert.Equal(t, ErrInviteExpired, err)
+
+	// Revoked
+	i, err = invs.Create(CreateInviteParams{OrgId: o.Id})
+	assert.Nil(t, err)
+	assert.Nil(t, invs.Revoke(i.Id))
+	_, _, err = us.SignUp(SignUpParams{Email: "invited6@mail.com", InviteCode: i.Code})
+	assert.Equal(t, ErrInvalidInviteCode, err)
+
+	// Required
+	us.RequireInvite = true
+	defer func() { us.RequireInvite = false }()
+	_, _, err = us.SignUp(SignUpParams{Email: "invited7@mail.com"})
+	assert.Equal(t, ErrInviteRequired, err)
+
+	list, err := invs.List(ListInvitesParams{InviteFilters: InviteFilters{OrgId: o.Id}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), list.Total)
+	list, err = invs.List(ListInvitesParams{ListArgs: ListArgs{Deleted: true}, InviteFilters: InviteFilters{OrgId: o.Id}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), list.Total)
+
+	// Invites must be to an existing org with a role it can use.
+	_, err = invs.Create(CreateInviteParams{OrgId: 1 << 40})
+	assert.Equal(t, ErrOrgInvalid, err)
+	_, err = invs.Create(CreateInviteParams{OrgId: o.Id, Role: 1 << 20})
+	assert.Equal(t, ErrRoleNotFound, err)
+}
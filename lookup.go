@@ -0,0 +1,105 @@
+package gus
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// userScope chooses which users a lookup can match, every lookup has to choose one.
+type userScope int
+
+const (
+	liveUsers    userScope = iota // Users which aren't deleted, suspended users included.
+	activeUsers                   // Users which aren't deleted or suspended.
+	deletedUsers                  // Only deleted users, erased users excluded since they can't be undeleted.
+	allUsers                      // Every user.
+)
+
+func (s userScope) cond() string {
+	switch s {
+	case activeUsers:
+		return "u.deleted = 0 AND u.suspended = 0"
+	case deletedUsers:
+		return "u.deleted = 1 AND u.erased = 0"
+	case allUsers:
+		return ""
+	}
+	return "u.deleted = 0"
+}
+
+// userLookup builds a query of the users table aliased as u. Each condition is parenthesized and ANDed with the
+// scope so an OR can't match users outside it.
+type userLookup struct {
+	scope userScope
+	conds []string
+	args  []interface{}
+}
+
+func lookupUsers(scope userScope) *userLookup {
+	return &userLookup{scope: scope}
+}
+
+func (l *userLookup) where(cond string, args ...interface{}) *userLookup {
+	l.conds = append(l.conds, "("+cond+")")
+	l.args = append(l.args, args...)
+	return l
+}
+
+func (l *userLookup) byId(id int64) *userLookup {
+	return l.where("u.id = ?", id)
+}
+
+// byName matches a sign-in name against the normalized email or username.
+func (l *userLookup) byName(n *Normalizer, name string) *userLookup {
+	emailKey, usernameKey := n.lookupKeys(name)
+	return l.where("u.email_norm = ? OR u.username_norm = ?", emailKey, usernameKey)
+}
+
+// query returns the SELECT of cols from from, which joins any other tables to "users u", and its args.
+func (l *userLookup) query(cols string, from string) (string, []interface{}) {
+	conds := l.conds
+	if c := l.scope.cond(); c != "" {
+		conds = append([]string{c}, conds...)
+	}
+	q := "SELECT " + cols + " FROM " + from
+	if len(conds) > 0 {
+		q += " WHERE " + strings.Join(conds, " AND ")
+	}
+	return q, l.args
+}
+
+// row runs the lookup for a single user.
+func (l *userLookup) row(q queryer, cols string, from string) *sql.Row {
+	query, args := l.query(cols, from)
+	return q.QueryRow(query+" LIMIT 1", args...)
+}
+
+// releaseKeys clears the normalized email and username of deleted users matching where so they can be used
+// again, they're claimed back by claimKeys if the users are undeleted.
+func releaseKeys(q queryer, where string, args ...interface{}) error {
+	_, err := q.Exec("UPDATE users SET email_norm = NULL, username_norm = NULL WHERE deleted = 1 AND ("+where+")", args...)
+	return err
+}
+
+// claimKeys restores the normalized email and username of a deleted user about to be undeleted, it returns
+// ErrEmailTaken or ErrUsernameTaken if another user has taken them since.
+func claimKeys(q queryer, n *Normalizer, id int64) error {
+	var email, username string
+	err := CheckNotFound(lookupUsers(deletedUsers).byId(id).row(q, "COALESCE(u.email, ''), COALESCE(u.username, '')", "users u").
+		Scan(&email, &username))
+	if err != nil {
+		return err
+	}
+	emailKey, usernameKey, err := n.keys(email, username)
+	if err != nil {
+		return err
+	}
+	if err = taken(q, "email_norm", emailKey, id, ErrEmailTaken); err != nil {
+		return err
+	}
+	if err = taken(q, "username_norm", usernameKey, id, ErrUsernameTaken); err != nil {
+		return err
+	}
+	_, err = q.Exec("UPDATE users SET email_norm = ?, username_norm = ? WHERE id = ?", emailKey, usernameKey, id)
+	return err
+}
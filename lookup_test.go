@@ -0,0 +1,68 @@
+package gus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestUserLookup(t *testing.T) {
+	q, args := lookupUsers(liveUsers).where("u.email_norm = ? OR u.username_norm = ?", "a", "b").query("u.id", "users u")
+	assert.Equal(t, "SELECT u.id FROM users u WHERE u.deleted = 0 AND (u.email_norm = ? OR u.username_norm = ?)", q)
+	assert.Equal(t, []interface{}{"a", "b"}, args)
+	q, _ = lookupUsers(activeUsers).byId(1).query("u.id", "users u")
+	assert.Equal(t, "SELECT u.id FROM users u WHERE u.deleted = 0 AND u.suspended = 0 AND (u.id = ?)", q)
+	q, _ = lookupUsers(allUsers).query("u.id", "users u")
+	assert.Equal(t, "SELECT u.id FROM users u", q)
+}
+
+func TestDeletedUsers(t *testing.T) {
+	password := "M0nk3yNutz5"
+	o, err := orgsv.Create(CreateOrgParams{Name: "Deleted Users"})
+	assert.Nil(t, err)
+	u, _, err := us.SignUp(SignUpParams{Email: "gone@mail.com", Password: password, OrgId: o.Id})
+	assert.Nil(t, err)
+	assert.Nil(t, us.Delete(u.Id))
+
+	// Deleted users can't sign in by email or be found.
+	_, err = us.SignIn(SignInParams{Email: "gone@mail.com", Password: password})
+	assert.Equal(t, ErrNotAuth, err)
+	_, _, err = us.GetByUsername("gone@mail.com")
+	assert.Equal(t, ErrNotFound, err)
+	exists, err := us.Exists(ExistsParams{Email: "gone@mail.com", Username: "gone@mail.com"})
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	// Their email can be signed up with again, then they can't be undeleted.
+	again, _, err := us.SignUp(SignUpParams{Email: "Gone@mail.com", Password: password, OrgId: o.Id})
+	assert.Nil(t, err)
+	assert.Equal(t, ErrEmailTaken, us.UnDelete(u.Id))
+	signedIn, err := us.SignIn(SignInParams{Email: "gone@mail.com", Password: password})
+	assert.Nil(t, err)
+	if assert.NotNil(t, signedIn) {
+		assert.Equal(t, again.Id, signedIn.Id)
+	}
+
+	// Once the email is free again they can.
+	assert.Nil(t, us.Delete(again.Id))
+	assert.Nil(t, us.UnDelete(u.Id))
+	signedIn, err = us.SignIn(SignInParams{Email: "gone@mail.com", Password: password})
+	assert.Nil(t, err)
+	if assert.NotNil(t, signedIn) {
+		assert.Equal(t, u.Id, signedIn.Id)
+	}
+	assert.Equal(t, ErrNotFound, us.UnDelete(u.Id))
+
+	// Users of a deleted org whose email has been taken stay deleted when it's undeleted.
+	orgsv.DeletePolicy = CascadeUsers
+	defer func() { orgsv.OrgOpts = OrgOpts{} }()
+	v, _, err := us.SignUp(SignUpParams{Email: "gone.too@mail.com", Password: password, OrgId: o.Id})
+	assert.Nil(t, err)
+	assert.Nil(t, orgsv.Delete(o.Id))
+	_, _, err = us.SignUp(SignUpParams{Email: "gone.too@mail.com", Password: password})
+	assert.Nil(t, err)
+	assert.Nil(t, orgsv.UnDelete(o.Id))
+	_, err = us.Get(u.Id)
+	assert.Nil(t, err)
+	_, err = us.Get(v.Id)
+	assert.Equal(t, ErrNotFound, err)
+}
@@ -0,0 +1,53 @@
+package gus
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+var maildirSeq int64
+
+// MaildirNotifier writes each notification to a maildir (tmp, new and cur sub directories) so that messages
+// can be inspected with a mail client or plain text editor during development.
+type MaildirNotifier struct {
+	Dir       string
+	From      string
+	Templates Templates // Optional will use DefaultTemplates.
+}
+
+// NewMaildirNotifier creates the maildir structure under dir if it doesn't exist.
+func NewMaildirNotifier(dir string, from string) (*MaildirNotifier, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, err
+		}
+	}
+	return &MaildirNotifier{Dir: dir, From: from, Templates: DefaultTemplates()}, nil
+}
+
+func (md *MaildirNotifier) Notify(n Notification) error {
+	t := md.Templates
+	if t == nil {
+		t = DefaultTemplates()
+	}
+	m, err := t.Render(n)
+	if err != nil {
+		return err
+	}
+	m.From = md.From
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%d_%d_%s.%s", time.Now().Unix(), os.Getpid(), atomic.AddInt64(&maildirSeq, 1), n.Kind, host)
+	tmp := filepath.Join(md.Dir, "tmp", name)
+	if err = ioutil.WriteFile(tmp, msg, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(md.Dir, "new", name))
+}
@@ -9,6 +9,7 @@ import (
 
 var orgsv *Orgs
 var us *Users
+var invs *Invites
 
 func TestMain(m *testing.M) {
 	dsn := fmt.Sprintf("%s:%s@tcp(127.0.0.1:%s)/gus_test?parseTime=true&multiStatements=true", "root", "rootPassword", "3306")
@@ -19,6 +20,7 @@ func TestMain(m *testing.M) {
 	}
 	orgsv = NewOrgs(db)
 	us = NewUsers(db, UserOpts{AuthAttempts: 5, AuthLockDuration: 1, ResetTokenExpiry: 1 })
+	invs = NewInvites(db, InviteOpts{})
 	code := m.Run()
 	os.Exit(code)
 }
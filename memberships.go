@@ -0,0 +1,204 @@
+package gus
+
+import (
+	"database/sql"
+	"time"
+)
+
+var (
+	ErrNotMember = ErrInvalid("That user is not a member of the org.")
+)
+
+// Membership of a user in an org, a user may belong to many orgs. The user's OrgId is their default org which is
+// used to build Claims at sign-in unless another org is requested.
+type Membership struct {
+	UserId    int64  `json:"user_id"`
+	OrgId     int64  `json:"org_id"`
+	OrgName   string `json:"org_name"`
+	Role      Role   `json:"role"`
+	Joined    int64  `json:"joined"`
+	Suspended bool   `json:"suspended"`
+}
+
+// AddMember adds the user to the org, if the user has no default org this org becomes their default.
+func (us *Orgs) AddMember(orgId int64, userId int64, role Role) error {
+	e := &Event{Kind: MemberAdded, OrgId: orgId, UserId: userId, Changes: map[string]Change{"user_id": {To: userId}, "role": {To: role}}}
+	return us.emit(e, AuditMemberAdd, func(tx *sql.Tx) error {
+		return addMember(tx, orgId, userId, role)
+	})
+}
+
+func addMember(tx *sql.Tx, orgId int64, userId int64, role Role) error {
+	var count int64
+	err := tx.QueryRow("SELECT count(id) FROM orgs WHERE id = ? AND deleted = 0", orgId).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	var userOrgId int64
+	err = CheckNotFound(tx.QueryRow("SELECT org_id FROM users WHERE id = ? AND deleted = 0", userId).Scan(&userOrgId))
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow("SELECT count(user_id) FROM memberships WHERE user_id = ? AND org_id = ?", userId, orgId).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrAlreadyMember
+	}
+	now := Milliseconds(time.Now())
+	_, err = tx.Exec("INSERT INTO memberships (user_id, org_id, role, joined, suspended) values (?, ?, ?, ?, ?)", userId, orgId, role, now, 0)
+	if err != nil {
+		return err
+	}
+	if userOrgId == 0 {
+		_, err = tx.Exec("UPDATE users SET org_id = ?, role = ?, updated = ?, version = version + 1 WHERE id = ?", orgId, role, now, userId)
+	}
+	return err
+}
+
+// moveMembership replaces the user's membership of their old default org with one of the new org unless
+// they're already a member.
+func moveMembership(tx *sql.Tx, userId int64, fromOrgId int64, toOrgId int64, role Role) error {
+	_, err := tx.Exec("DELETE FROM memberships WHERE user_id = ? AND org_id = ?", userId, fromOrgId)
+	if err != nil {
+		return err
+	}
+	var count int64
+	err = tx.QueryRow("SELECT count(user_id) FROM memberships WHERE user_id = ? AND org_id = ?", userId, toOrgId).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO memberships (user_id, org_id, role, joined, suspended) values (?, ?, ?, ?, ?)",
+		userId, toOrgId, role, Milliseconds(time.Now()), 0)
+	return err
+}
+
+// RemoveMember removes the user from the org, if it was their default org the earliest remaining membership
+// becomes the default.
+func (us *Orgs) RemoveMember(orgId int64, userId int64) error {
+	e := &Event{Kind: MemberRemoved, OrgId: orgId, UserId: userId, Changes: map[string]Change{"user_id": {From: userId}}}
+	return us.emit(e, AuditMemberRemove, func(tx *sql.Tx) error {
+		return removeMember(tx, orgId, userId)
+	})
+}
+
+func removeMember(tx *sql.Tx, orgId int64, userId int64) error {
+	err := CheckUpdated(tx.Exec("DELETE FROM memberships WHERE user_id = ? AND org_id = ?", userId, orgId))
+	if err != nil {
+		if _, ok := err.(*NotFoundError); ok {
+			return ErrNotMember
+		}
+		return err
+	}
+	var userOrgId int64
+	err = CheckNotFound(tx.QueryRow("SELECT org_id FROM users WHERE id = ?", userId).Scan(&userOrgId))
+	if err != nil || userOrgId != orgId {
+		return err
+	}
+	var nextOrgId int64
+	var nextRole Role
+	err = tx.QueryRow("SELECT org_id, role FROM memberships WHERE user_id = ? ORDER BY joined LIMIT 1", userId).Scan(&nextOrgId, &nextRole)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	_, err = tx.Exec("UPDATE users SET org_id = ?, role = ?, updated = ?, version = version + 1 WHERE id = ?", nextOrgId, nextRole, Milliseconds(time.Now()), userId)
+	return err
+}
+
+// SuspendMember prevents the user signing in to the org without affecting their other memberships.
+func (us *Orgs) SuspendMember(orgId int64, userId int64) error {
+	return us.emit(&Event{Kind: MemberSuspended, OrgId: orgId, UserId: userId}, AuditMemberSuspend, func(tx *sql.Tx) error {
+		return setMemberSuspended(tx, orgId, userId, true)
+	})
+}
+
+func (us *Orgs) RestoreMember(orgId int64, userId int64) error {
+	return us.emit(&Event{Kind: MemberRestored, OrgId: orgId, UserId: userId}, AuditMemberRestore, func(tx *sql.Tx) error {
+		return setMemberSuspended(tx, orgId, userId, false)
+	})
+}
+
+func setMemberSuspended(q queryer, orgId int64, userId int64, suspended bool) error {
+	return CheckUpdated(q.Exec("UPDATE memberships SET suspended = ? WHERE user_id = ? AND org_id = ?", suspended, userId, orgId))
+}
+
+// Members lists the memberships of an org.
+func (us *Orgs) Members(orgId int64) ([]*Membership, error) {
+	return queryMemberships(us.db, "m.org_id = ?", orgId)
+}
+
+// Memberships lists the orgs a user belongs to.
+func (us *Users) Memberships(userId int64) ([]*Membership, error) {
+	return queryMemberships(us.db, "m.user_id = ?", userId)
+}
+
+const membershipCols = "m.user_id, m.org_id, o.name, m.role, m.joined, m.suspended"
+
+func queryMemberships(q queryer, where string, arg interface{}) ([]*Membership, error) {
+	rows, err := q.Query("SELECT "+membershipCols+" FROM memberships m JOIN orgs o ON m.org_id = o.id "+
+		"WHERE "+where+" AND o.deleted = 0 ORDER BY m.joined", arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*Membership{}
+	for rows.Next() {
+		m, err := scanMembership(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, m)
+	}
+	return items, rows.Err()
+}
+
+func scanMembership(row scanner) (*Membership, error) {
+	var m Membership
+	var suspended int
+	err := CheckNotFound(row.Scan(&m.UserId, &m.OrgId, &m.OrgName, &m.Role, &m.Joined, &suspended))
+	if err != nil {
+		return nil, err
+	}
+	m.Suspended = suspended > 0
+	return &m, nil
+}
+
+// claimsFor returns the claims of the user when acting in the org.
+func (us *Users) claimsFor(userId int64, orgId int64) (*Claims, error) {
+	return memberClaims(us.db, userId, orgId)
+}
+
+func memberClaims(q queryer, userId int64, orgId int64) (*Claims, error) {
+	var role Role
+	var suspended, orgSuspended int
+	err := CheckNotFound(q.QueryRow("SELECT m.role, m.suspended, o.suspended FROM memberships m JOIN orgs o ON m.org_id = o.id "+
+		"WHERE m.user_id = ? AND m.org_id = ? AND o.deleted = 0 LIMIT 1", userId, orgId).Scan(&role, &suspended, &orgSuspended))
+	if err != nil {
+		return nil, err
+	}
+	if orgSuspended == 0 {
+		if s, err := ancestorSuspended(q, orgId); err != nil {
+			return nil, err
+		} else if s {
+			orgSuspended = 1
+		}
+	}
+	return &Claims{UserId: userId, Role: role, OrgId: orgId, OrgSuspended: orgSuspended > 0, MembershipSuspended: suspended > 0}, nil
+}
+
+// memberOrgIds returns the ids of every org the user belongs to.
+func (us *Users) memberOrgIds(userId int64) ([]int64, error) {
+	ms, err := us.Memberships(userId)
+	if err != nil {
+		return nil, err
+	}
+	ids := []int64{}
+	for _, m := range ms {
+		ids = append(ids, m.OrgId)
+	}
+	return ids, nil
+}
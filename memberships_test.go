@@ -0,0 +1,70 @@
+package gus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestOrgs_Memberships(t *testing.T) {
+	a, err := orgsv.Create(CreateOrgParams{Name: "Client A"})
+	assert.Nil(t, err)
+	b, err := orgsv.Create(CreateOrgParams{Name: "Client B"})
+	assert.Nil(t, err)
+	password := "M0nk3yNutz5"
+	u, _, err := us.SignUp(SignUpParams{Email: "consultant@mail.com", Password: password, OrgId: a.Id, Role: 2})
+	assert.Nil(t, err)
+	assert.Nil(t, orgsv.AddMember(b.Id, u.Id, 5))
+	assert.Equal(t, ErrAlreadyMember, orgsv.AddMember(b.Id, u.Id, 5))
+
+	ms, err := us.Memberships(u.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ms))
+	ms, err = orgsv.Members(b.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ms))
+	assert.Equal(t, Role(5), ms[0].Role)
+	users, err := us.List(ListUsersParams{UserFilters: UserFilters{OrgId: b.Id}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), users.Total)
+
+	// Default org
+	uc, err := us.SignIn(SignInParams{Email: u.Email, Password: password})
+	assert.Nil(t, err)
+	assert.Equal(t, a.Id, uc.Claims.OrgId)
+	assert.Equal(t, Role(2), uc.Claims.Role)
+	assert.Equal(t, []int64{a.Id, b.Id}, uc.OrgIds)
+
+	// Switch org
+	uc, err = us.SignIn(SignInParams{Email: u.Email, Password: password, OrgId: b.Id})
+	assert.Nil(t, err)
+	assert.Equal(t, b.Id, uc.Claims.OrgId)
+	assert.Equal(t, Role(5), uc.Claims.Role)
+	_, err = us.SignIn(SignInParams{Email: u.Email, Password: password, OrgId: 33453453})
+	assert.Equal(t, ErrNotAuth, err)
+
+	// Suspended membership only affects that org
+	assert.Nil(t, orgsv.SuspendMember(b.Id, u.Id))
+	_, err = us.SignIn(SignInParams{Email: u.Email, Password: password, OrgId: b.Id})
+	assert.Equal(t, ErrNotAuth, err)
+	_, err = us.SignIn(SignInParams{Email: u.Email, Password: password})
+	assert.Nil(t, err)
+	assert.Nil(t, orgsv.RestoreMember(b.Id, u.Id))
+
+	// Removing the default org falls back to the remaining membership
+	assert.Nil(t, orgsv.RemoveMember(a.Id, u.Id))
+	assert.Equal(t, ErrNotMember, orgsv.RemoveMember(a.Id, u.Id))
+	u, err = us.Get(u.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, b.Id, u.OrgId)
+	assert.Equal(t, Role(5), u.Role)
+
+	// Migrate existing single org users
+	_, err = us.db.Exec("DELETE FROM memberships WHERE user_id = ?", u.Id)
+	assert.Nil(t, err)
+	assert.Nil(t, MigrateMemberships(us.db))
+	assert.Nil(t, MigrateMemberships(us.db))
+	ms, err = us.Memberships(u.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ms))
+	assert.Equal(t, b.Id, ms[0].OrgId)
+}
@@ -0,0 +1,164 @@
+package gus
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+)
+
+var membershipMigrations = map[string]string{
+	"mysql":   MigrateMembershipsMySql,
+	"sqlite3": MigrateMembershipsSqlLite,
+}
+
+// MigrateErased adds the erased and deleted_at columns of users to a database created before Users.Erase, it's
+// safe to run more than once. Users deleted before have no deleted_at, PurgeDeleted goes by when they were last
+// updated instead.
+func MigrateErased(db *sql.DB) error {
+	for _, col := range []string{"erased", "deleted_at"} {
+		if err := addColumn(db, "users", col, "BIGINT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateOutboxUsers adds the user_id column of the outbox, which Users.Erase finds a user's events by, to a
+// database created before it and fills it in from the payloads. It's safe to run more than once.
+func MigrateOutboxUsers(db *sql.DB) error {
+	if err := addColumn(db, "outbox", "user_id", "BIGINT NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	rows, err := db.Query("SELECT id, payload FROM outbox WHERE user_id = 0")
+	if err != nil {
+		return err
+	}
+	userIds := map[int64]int64{}
+	for rows.Next() {
+		var id int64
+		var payload string
+		if err = rows.Scan(&id, &payload); err != nil {
+			rows.Close()
+			return err
+		}
+		var e Event
+		if json.Unmarshal([]byte(payload), &e) == nil && e.UserId != 0 {
+			userIds[id] = e.UserId
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for id, userId := range userIds {
+		if _, err = db.Exec("UPDATE outbox SET user_id = ? WHERE id = ?", userId, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateOrgTree adds the parent_id column of orgs to a database created before org hierarchies, existing orgs
+// become root orgs. It's safe to run more than once.
+func MigrateOrgTree(db *sql.DB) error {
+	return addColumn(db, "orgs", "parent_id", "BIGINT NULL DEFAULT 0")
+}
+
+// MigrateCascade adds the columns of users which record the org whose suspension or deletion cascaded to them, to
+// a database created before CascadeUsers. It's safe to run more than once.
+func MigrateCascade(db *sql.DB) error {
+	for _, col := range []string{"suspended_by_org", "deleted_by_org"} {
+		if err := addColumn(db, "users", col, "BIGINT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateTables creates the tables of the seed which a database created by an earlier version lacks, such as
+// roles, audit_events, outbox, user_attributes, invites and email_changes, and inserts the built-in roles when it
+// creates the roles table. Tables which exist are left alone, the other Migrate functions add their new columns.
+// It's safe to run more than once.
+func MigrateTables(db *sql.DB) error {
+	_, err := db.Exec("SELECT id FROM roles LIMIT 1")
+	missingRoles := err != nil
+	for _, stmt := range strings.Split(seeds[driverName], ";") {
+		stmt = strings.TrimSpace(stmt)
+		if !strings.HasPrefix(stmt, "CREATE TABLE ") {
+			continue
+		}
+		if _, err = db.Exec("CREATE TABLE IF NOT EXISTS " + strings.TrimPrefix(stmt, "CREATE TABLE ")); err != nil {
+			return err
+		}
+	}
+	if missingRoles {
+		return seedRoles(db)
+	}
+	return nil
+}
+
+// MigrateIndexes creates the indexes of the seed which a database created by an earlier version lacks, they back
+// the sort keys of paged lists and the lookups of memberships, audit events and the outbox. Run it after the
+// Migrate functions which add columns, it's safe to run more than once.
+func MigrateIndexes(db *sql.DB) error {
+	for _, stmt := range strings.Split(seeds[driverName], ";") {
+		stmt = strings.TrimSpace(stmt)
+		if !strings.HasPrefix(stmt, "CREATE INDEX ") {
+			continue
+		}
+		exists, err := indexExists(db, strings.Fields(stmt)[2])
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err = db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexExists(db *sql.DB, name string) (bool, error) {
+	q := "SELECT count(*) FROM sqlite_master WHERE type = 'index' AND name = ?"
+	if driverName == "mysql" {
+		q = "SELECT count(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND index_name = ?"
+	}
+	var count int64
+	err := db.QueryRow(q, name).Scan(&count)
+	return count > 0, err
+}
+
+// MigrateVersions adds the version columns of users and orgs, used for optimistic concurrency, to a database
+// created before them. Existing rows start at version 0, it's safe to run more than once.
+func MigrateVersions(db *sql.DB) error {
+	for _, table := range []string{"users", "orgs"} {
+		if err := addColumn(db, table, "version", "BIGINT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumn adds the column to the table unless it's already there.
+func addColumn(db *sql.DB, table string, column string, definition string) error {
+	if _, err := db.Exec("SELECT " + column + " FROM " + table + " LIMIT 1"); err == nil {
+		return nil
+	}
+	_, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// MigrateMemberships upgrades a database created before users could belong to many orgs. It creates the
+// memberships table and adds a membership for each user's existing org, it's safe to run more than once.
+func MigrateMemberships(db *sql.DB) error {
+	_, err := db.Exec(membershipMigrations[driverName])
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO memberships (user_id, org_id, role, joined, suspended) " +
+		"SELECT u.id, u.org_id, COALESCE(u.role, 0), u.created, 0 FROM users u WHERE u.org_id > 0 " +
+		"AND NOT EXISTS (SELECT 1 FROM memberships m WHERE m.user_id = u.id AND m.org_id = u.org_id)")
+	return err
+}
@@ -0,0 +1,67 @@
+package gus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMigrateOrgTree(t *testing.T) {
+	assert.Nil(t, MigrateOrgTree(us.db))
+	assert.Nil(t, MigrateOrgTree(us.db))
+	o, err := orgsv.Create(CreateOrgParams{Name: "Migrated Tree Co."})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), o.ParentId)
+}
+
+func TestMigrateTables(t *testing.T) {
+	var before, after int64
+	assert.Nil(t, us.db.QueryRow("SELECT count(id) FROM roles").Scan(&before))
+	assert.Nil(t, MigrateTables(us.db))
+	assert.Nil(t, MigrateTables(us.db))
+	assert.Nil(t, MigrateCascade(us.db))
+	assert.Nil(t, MigrateCascade(us.db))
+	assert.Nil(t, us.db.QueryRow("SELECT count(id) FROM roles").Scan(&after))
+	assert.Equal(t, before, after)
+}
+
+func TestMigrateVersions(t *testing.T) {
+	assert.Nil(t, MigrateVersions(us.db))
+	assert.Nil(t, MigrateVersions(us.db))
+	o, err := orgsv.Create(CreateOrgParams{Name: "Versioned Co."})
+	assert.Nil(t, err)
+	got, err := orgsv.Get(o.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), got.Version)
+}
+
+func TestMigrateIndexes(t *testing.T) {
+	drop := "DROP INDEX users_updated"
+	if driverName == "mysql" {
+		drop += " ON users"
+	}
+	_, err := us.db.Exec(drop)
+	assert.Nil(t, err)
+	exists, err := indexExists(us.db, "users_updated")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+	assert.Nil(t, MigrateIndexes(us.db))
+	assert.Nil(t, MigrateIndexes(us.db))
+	for _, name := range []string{"users_updated", "memberships_org", "webhook_deliveries_due"} {
+		exists, err = indexExists(us.db, name)
+		assert.Nil(t, err)
+		assert.True(t, exists, name)
+	}
+}
+
+func TestMigrateOutboxUsers(t *testing.T) {
+	uo := NewUsers(us.db, UserOpts{Outbox: NewOutbox(us.db, OutboxOpts{})})
+	u, _, err := uo.SignUp(SignUpParams{Email: "migrate.outbox@mail.com", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+	_, err = us.db.Exec("UPDATE outbox SET user_id = 0 WHERE user_id = ?", u.Id)
+	assert.Nil(t, err)
+	assert.Nil(t, MigrateOutboxUsers(us.db))
+	assert.Nil(t, MigrateOutboxUsers(us.db))
+	var count int
+	assert.Nil(t, us.db.QueryRow("SELECT count(*) FROM outbox WHERE user_id = ? AND kind = ?", u.Id, UserCreated).Scan(&count))
+	assert.Equal(t, 1, count)
+}
@@ -8,6 +8,8 @@ CREATE TABLE users (
     uid VARCHAR(36) NULL,
     username VARCHAR(128) NULL,
     email VARCHAR(128) NULL,
+    email_norm VARCHAR(128) NULL,
+    username_norm VARCHAR(128) NULL,
     first_name VARCHAR(128) NULL,
     last_name VARCHAR(128) NULL,
     phone VARCHAR(30) NULL,
@@ -18,11 +20,38 @@ CREATE TABLE users (
     created BIGINT NULL DEFAULT 0,
     suspended tinyint(4),
     deleted tinyint(4),
+    suspended_by_org BIGINT NULL DEFAULT 0,
+    deleted_by_org BIGINT NULL DEFAULT 0,
     role BIGINT,
 	passive TINYINT(2) NULL,
 	activated TINYINT(2) NULL,
-    CONSTRAINT UC_Email UNIQUE (email),
-    CONSTRAINT UC_Username UNIQUE (username)
+    version BIGINT NOT NULL DEFAULT 0,
+    erased BIGINT NOT NULL DEFAULT 0,
+    deleted_at BIGINT NOT NULL DEFAULT 0,
+    CONSTRAINT UC_EmailNorm UNIQUE (email_norm),
+    CONSTRAINT UC_UsernameNorm UNIQUE (username_norm),
+    FULLTEXT KEY users_search (first_name, last_name, email, username, phone)
+);
+CREATE INDEX users_updated ON users (updated, id);
+CREATE INDEX users_created ON users (created, id);
+CREATE INDEX users_org ON users (org_id, updated, id);
+
+DROP TABLE IF EXISTS user_attributes;
+CREATE TABLE user_attributes (
+    user_id BIGINT NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    str_value VARCHAR(1024) NULL,
+    num_value DOUBLE NULL,
+    PRIMARY KEY (user_id, name)
+);
+
+DROP TABLE IF EXISTS org_attributes;
+CREATE TABLE org_attributes (
+    org_id BIGINT NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    str_value VARCHAR(1024) NULL,
+    num_value DOUBLE NULL,
+    PRIMARY KEY (org_id, name)
 );
 
 DROP TABLE IF EXISTS password_resets;
@@ -35,6 +64,133 @@ CREATE TABLE password_resets (
     deleted tinyint(4)
 );
 
+DROP TABLE IF EXISTS email_changes;
+CREATE TABLE email_changes (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    user_id BIGINT NOT NULL,
+    old_email VARCHAR(128) NULL,
+    new_email VARCHAR(128) NOT NULL,
+    token VARCHAR(256) NOT NULL,
+    created BIGINT NULL DEFAULT 0,
+    deleted tinyint(4)
+);
+
+DROP TABLE IF EXISTS invites;
+CREATE TABLE invites (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    code VARCHAR(30) NOT NULL,
+    org_id BIGINT,
+    role BIGINT,
+    max_uses BIGINT NULL DEFAULT 0,
+    uses BIGINT NULL DEFAULT 0,
+    expires BIGINT NULL DEFAULT 0,
+    created BIGINT NULL DEFAULT 0,
+    updated BIGINT NULL DEFAULT 0,
+    deleted tinyint(4),
+    CONSTRAINT UC_Code UNIQUE (code)
+);
+
+DROP TABLE IF EXISTS invite_redemptions;
+CREATE TABLE invite_redemptions (
+    invite_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    created BIGINT NULL DEFAULT 0
+);
+
+DROP TABLE IF EXISTS roles;
+CREATE TABLE roles (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(128) NOT NULL,
+    level BIGINT NOT NULL DEFAULT 0,
+    org_id BIGINT NOT NULL DEFAULT 0,
+    built_in tinyint(4),
+    created BIGINT NULL DEFAULT 0,
+    updated BIGINT NULL DEFAULT 0,
+    deleted tinyint(4)
+);
+
+DROP TABLE IF EXISTS role_permissions;
+CREATE TABLE role_permissions (
+    role_id BIGINT NOT NULL,
+    permission VARCHAR(64) NOT NULL,
+    PRIMARY KEY (role_id, permission)
+);
+
+DROP TABLE IF EXISTS memberships;
+CREATE TABLE memberships (
+    user_id BIGINT NOT NULL,
+    org_id BIGINT NOT NULL,
+    role BIGINT,
+    joined BIGINT NULL DEFAULT 0,
+    suspended tinyint(4),
+    PRIMARY KEY (user_id, org_id)
+);
+CREATE INDEX memberships_org ON memberships (org_id, user_id);
+
+DROP TABLE IF EXISTS org_invitations;
+CREATE TABLE org_invitations (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    org_id BIGINT NOT NULL,
+    email VARCHAR(128) NOT NULL,
+    email_norm VARCHAR(128) NULL,
+    role BIGINT,
+    status VARCHAR(16) NOT NULL,
+    created BIGINT NULL DEFAULT 0,
+    updated BIGINT NULL DEFAULT 0
+);
+
+DROP TABLE IF EXISTS audit_events;
+CREATE TABLE audit_events (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    action VARCHAR(64) NOT NULL,
+    actor_id BIGINT NOT NULL,
+    target_type VARCHAR(16) NOT NULL,
+    target_id BIGINT NOT NULL,
+    ip VARCHAR(64) NULL,
+    changes TEXT NULL,
+    created BIGINT NULL DEFAULT 0
+);
+CREATE INDEX audit_events_target ON audit_events (target_type, target_id);
+CREATE INDEX audit_events_actor ON audit_events (actor_id);
+
+DROP TABLE IF EXISTS outbox;
+CREATE TABLE outbox (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    kind VARCHAR(64) NOT NULL,
+    user_id BIGINT NOT NULL DEFAULT 0,
+    payload TEXT NOT NULL,
+    created BIGINT NULL DEFAULT 0,
+    fanned_out tinyint(4)
+);
+CREATE INDEX outbox_fanned_out ON outbox (fanned_out, id);
+CREATE INDEX outbox_user ON outbox (user_id);
+
+DROP TABLE IF EXISTS webhooks;
+CREATE TABLE webhooks (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    url VARCHAR(2048) NOT NULL,
+    secret VARCHAR(256) NOT NULL,
+    kinds TEXT NOT NULL,
+    created BIGINT NULL DEFAULT 0,
+    updated BIGINT NULL DEFAULT 0,
+    deleted tinyint(4)
+);
+
+DROP TABLE IF EXISTS webhook_deliveries;
+CREATE TABLE webhook_deliveries (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    outbox_id BIGINT NOT NULL,
+    webhook_id BIGINT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    attempts INT NOT NULL,
+    next_attempt BIGINT NULL DEFAULT 0,
+    last_error TEXT NULL,
+    created BIGINT NULL DEFAULT 0,
+    updated BIGINT NULL DEFAULT 0
+);
+CREATE INDEX webhook_deliveries_due ON webhook_deliveries (status, next_attempt);
+CREATE INDEX webhook_deliveries_outbox ON webhook_deliveries (outbox_id);
+
 DROP TABLE IF EXISTS password_attempts;
 CREATE TABLE password_attempts (
     username VARCHAR(250),
@@ -44,6 +200,7 @@ CREATE TABLE password_attempts (
 DROP TABLE IF EXISTS orgs;
 CREATE TABLE orgs (
     id INT PRIMARY KEY AUTO_INCREMENT,
+    parent_id BIGINT NULL DEFAULT 0,
     name VARCHAR(128) NOT NULL,
     street VARCHAR(512) NULL,
     suburb VARCHAR(512) NULL,
@@ -54,7 +211,23 @@ CREATE TABLE orgs (
     created BIGINT NULL DEFAULT 0,
     updated BIGINT NULL DEFAULT 0,
     suspended tinyint(4),
-    deleted tinyint(4)
+    deleted tinyint(4),
+    version BIGINT NOT NULL DEFAULT 0
 );
+CREATE INDEX orgs_updated ON orgs (updated, id);
+CREATE INDEX orgs_created ON orgs (created, id);
+CREATE INDEX orgs_name ON orgs (name, id);
+CREATE INDEX orgs_parent ON orgs (parent_id);
+
+`
 
+const MigrateMembershipsMySql = `
+CREATE TABLE IF NOT EXISTS memberships (
+    user_id BIGINT NOT NULL,
+    org_id BIGINT NOT NULL,
+    role BIGINT,
+    joined BIGINT NULL DEFAULT 0,
+    suspended tinyint(4),
+    PRIMARY KEY (user_id, org_id)
+);
 `
@@ -0,0 +1,217 @@
+package gus
+
+import (
+	"database/sql"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalizer canonicalizes emails and usernames. Users are looked up by the canonical forms, kept in the unique
+// email_norm and username_norm columns, so " Bob@Example.com" and "bob@example.com" are the same user.
+type Normalizer struct {
+	// When true the local part of an email (before the @) keeps its case, domains are always lower case.
+	CaseSensitiveLocal bool
+	// Optional, converts internationalized domains to ASCII, e.g. idna.Lookup.ToASCII from golang.org/x/net/idna.
+	DomainToASCII func(domain string) (string, error)
+}
+
+// DefaultNormalizer is used when UserOpts.Normalizer or OrgOpts.Normalizer isn't set.
+var DefaultNormalizer = &Normalizer{}
+
+// Clean trims and NFKC normalizes s, emails and usernames are stored this way for display.
+func (n *Normalizer) Clean(s string) string {
+	return norm.NFKC.String(strings.TrimSpace(s))
+}
+
+// Email returns the canonical form of an email, ErrEmailInvalid if the domain can't be converted to ASCII.
+func (n *Normalizer) Email(email string) (string, error) {
+	email = n.Clean(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return strings.ToLower(email), nil
+	}
+	local, domain := email[:at], strings.TrimSuffix(strings.ToLower(email[at+1:]), ".")
+	if !n.CaseSensitiveLocal {
+		local = strings.ToLower(local)
+	}
+	if n.DomainToASCII != nil {
+		d, err := n.DomainToASCII(domain)
+		if err != nil {
+			return "", ErrEmailInvalid
+		}
+		domain = strings.ToLower(d)
+	}
+	return local + "@" + domain, nil
+}
+
+// Username returns the canonical form of a username, usernames are never case sensitive.
+func (n *Normalizer) Username(username string) string {
+	return strings.ToLower(n.Clean(username))
+}
+
+// keys returns the email_norm and username_norm of a user, a username which is the email shares its canonical form.
+func (n *Normalizer) keys(email string, username string) (string, string, error) {
+	emailKey, err := n.Email(email)
+	if err != nil {
+		return "", "", err
+	}
+	if strings.EqualFold(n.Clean(username), n.Clean(email)) {
+		return emailKey, emailKey, nil
+	}
+	return emailKey, n.Username(username), nil
+}
+
+// lookupKeys returns the canonical forms of a sign-in name, which may be an email or a username.
+func (n *Normalizer) lookupKeys(name string) (string, string) {
+	emailKey, err := n.Email(name)
+	if err != nil {
+		emailKey = n.Username(name)
+	}
+	return emailKey, n.Username(name)
+}
+
+func (us *Users) normalizer() *Normalizer {
+	if us.Normalizer == nil {
+		return DefaultNormalizer
+	}
+	return us.Normalizer
+}
+
+func (us *Orgs) normalizer() *Normalizer {
+	if us.Normalizer == nil {
+		return DefaultNormalizer
+	}
+	return us.Normalizer
+}
+
+// MigrateNormalized adds the email_norm and username_norm columns, and the email_norm column of invitations, to a
+// database created before them, fills them in with n (nil for DefaultNormalizer) and makes the users' unique. It
+// fails if two users normalize to the same email or username, one of them must be changed first. On MySQL the
+// unique constraints of the email and username columns are dropped so deleted users no longer block their reuse,
+// SQLite can't drop them. It's safe to run more than once.
+func MigrateNormalized(db *sql.DB, n *Normalizer) error {
+	if n == nil {
+		n = DefaultNormalizer
+	}
+	for _, col := range []string{"email_norm", "username_norm"} {
+		if err := addColumn(db, "users", col, "VARCHAR(128) NULL"); err != nil {
+			return err
+		}
+	}
+	if err := addColumn(db, "org_invitations", "email_norm", "VARCHAR(128) NULL"); err != nil {
+		return err
+	}
+	err := Tx(db, func(tx *sql.Tx) error {
+		// Deleted users don't hold their email and username, see releaseKeys.
+		_, err := tx.Exec("UPDATE users SET email_norm = NULL, username_norm = NULL WHERE deleted = 1")
+		if err != nil {
+			return err
+		}
+		rows, err := tx.Query("SELECT id, COALESCE(email, ''), COALESCE(username, '') FROM users WHERE deleted = 0")
+		if err != nil {
+			return err
+		}
+		type key struct {
+			id              int64
+			email, username string
+		}
+		var keys []key
+		for rows.Next() {
+			var k key
+			if err = rows.Scan(&k.id, &k.email, &k.username); err != nil {
+				rows.Close()
+				return err
+			}
+			if k.email, k.username, err = n.keys(k.email, k.username); err != nil {
+				rows.Close()
+				return err
+			}
+			keys = append(keys, k)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		for _, k := range keys {
+			_, err = tx.Exec("UPDATE users SET email_norm = ?, username_norm = ? WHERE id = ?", k.email, k.username, k.id)
+			if err != nil {
+				return err
+			}
+		}
+		return migrateInvitationKeys(tx, n)
+	})
+	if err != nil {
+		return err
+	}
+	for index, col := range map[string]string{"UC_EmailNorm": "email_norm", "UC_UsernameNorm": "username_norm"} {
+		if driverName != "mysql" {
+			if _, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + index + " ON users (" + col + ")"); err != nil {
+				return err
+			}
+			continue
+		}
+		exists, err := mysqlIndexExists(db, index)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if _, err = db.Exec("CREATE UNIQUE INDEX " + index + " ON users (" + col + ")"); err != nil {
+				return err
+			}
+		}
+	}
+	if driverName != "mysql" {
+		return nil
+	}
+	for _, index := range []string{"UC_Email", "UC_Username"} {
+		exists, err := mysqlIndexExists(db, index)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if _, err = db.Exec("DROP INDEX " + index + " ON users"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateInvitationKeys fills in the email_norm of invitations, an email which can't be normalized keeps its
+// lower case form.
+func migrateInvitationKeys(tx *sql.Tx, n *Normalizer) error {
+	rows, err := tx.Query("SELECT id, email FROM org_invitations")
+	if err != nil {
+		return err
+	}
+	keys := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var email string
+		if err = rows.Scan(&id, &email); err != nil {
+			rows.Close()
+			return err
+		}
+		if keys[id], err = n.Email(email); err != nil {
+			keys[id] = n.Username(email)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for id, key := range keys {
+		if _, err = tx.Exec("UPDATE org_invitations SET email_norm = ? WHERE id = ?", key, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mysqlIndexExists(db *sql.DB, index string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT count(*) FROM information_schema.statistics WHERE table_schema = DATABASE() "+
+		"AND table_name = 'users' AND index_name = ?", index).Scan(&count)
+	return count > 0, err
+}
@@ -0,0 +1,66 @@
+package gus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNormalizer(t *testing.T) {
+	n := &Normalizer{}
+	email, err := n.Email(" Bob.Smith@Example.COM. ")
+	assert.Nil(t, err)
+	assert.Equal(t, "bob.smith@example.com", email)
+	email, err = n.Email("ｂｏｂ@ｅｘａｍｐｌｅ.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "bob@example.com", email)
+	assert.Equal(t, "bob", n.Username(" BOB "))
+
+	n = &Normalizer{CaseSensitiveLocal: true, DomainToASCII: func(d string) (string, error) {
+		if d == "bücher.example" {
+			return "xn--bcher-kva.example", nil
+		}
+		return d, nil
+	}}
+	email, err = n.Email("Bob@Bücher.Example")
+	assert.Nil(t, err)
+	assert.Equal(t, "Bob@xn--bcher-kva.example", email)
+}
+
+func TestSignUp_Normalized(t *testing.T) {
+	o, err := orgsv.Create(CreateOrgParams{Name: "Normalized"})
+	assert.Nil(t, err)
+	u, _, err := us.SignUp(SignUpParams{Email: " Norm@Mail.com", Password: "M0nk3yNutz5", OrgId: o.Id})
+	assert.Nil(t, err)
+	assert.Equal(t, "Norm@Mail.com", u.Email)
+	_, _, err = us.SignUp(SignUpParams{Email: "norm@mail.COM", Password: "M0nk3yNutz5", OrgId: o.Id})
+	assert.Equal(t, ErrEmailTaken, err)
+	_, _, err = us.SignUp(SignUpParams{Email: "ｎｏｒｍ@mail.com", Password: "M0nk3yNutz5", OrgId: o.Id})
+	assert.Equal(t, ErrEmailTaken, err)
+
+	signedIn, err := us.SignIn(SignInParams{Email: "NORM@mail.com", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+	if assert.NotNil(t, signedIn) {
+		assert.Equal(t, u.Id, signedIn.Id)
+	}
+	token, err := us.ResetPassword(ResetPasswordParams{Email: "norm@MAIL.com"})
+	assert.Nil(t, err)
+	assert.Nil(t, us.ChangePassword(ChangePasswordParams{Email: "NORM@mail.com", ResetToken: token, NewPassword: "M0nk3yNutz6"}))
+
+	// Changing only the case of the email is allowed, taking another user's isn't.
+	email := "norm@mail.com"
+	assert.Nil(t, us.Update(UpdateUserParams{Id: &u.Id, Email: &email}))
+	v, _, err := us.SignUp(SignUpParams{Email: "other.norm@mail.com", Password: "M0nk3yNutz5", OrgId: o.Id})
+	assert.Nil(t, err)
+	email = "NORM@mail.com"
+	assert.Equal(t, ErrEmailTaken, us.Update(UpdateUserParams{Id: &v.Id, Email: &email}))
+
+	// Usernames are case insensitive too.
+	f := false
+	uf := NewUsers(us.db, UserOpts{UsernameIsEmail: &f, AuthAttempts: 5})
+	_, _, err = uf.SignUp(SignUpParams{Username: "Normal", Email: "normal@mail.com", Password: "M0nk3yNutz5", OrgId: o.Id})
+	assert.Nil(t, err)
+	_, _, err = uf.SignUp(SignUpParams{Username: "NORMAL ", Email: "normal2@mail.com", Password: "M0nk3yNutz5", OrgId: o.Id})
+	assert.Equal(t, ErrUsernameTaken, err)
+	_, err = uf.SignIn(SignInParams{Username: "normal", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,78 @@
+package gus
+
+import (
+	"sync"
+)
+
+type NotificationKind string
+
+const (
+	NotifyActivation         NotificationKind = "activation"           // Sent on SignUp with a token to set the first password.
+	NotifyPasswordReset      NotificationKind = "password_reset"       // Sent on ResetPassword with a reset token.
+	NotifyLockout            NotificationKind = "lockout"              // Sent when too many sign-in attempts lock the account.
+	NotifyEmailChangeConfirm NotificationKind = "email_change_confirm" // Sent to the new address with the confirmation token.
+	NotifyEmailChangeNotice  NotificationKind = "email_change_notice"  // Sent to the old address when a change is requested.
+	NotifyEmailChanged       NotificationKind = "email_changed"        // Sent to the old address once a change is confirmed.
+)
+
+// Notification is a message gus wants delivered to a user, the Notifier decides how it's rendered and sent.
+type Notification struct {
+	Kind  NotificationKind  `json:"kind"`
+	To    string            `json:"to"`
+	User  *User             `json:"user"`
+	Token string            `json:"token,omitempty"`
+	Data  map[string]string `json:"data,omitempty"` // Extra values made available to templates.
+}
+
+// Notifier delivers notifications. Errors are logged but will not fail the operation which triggered the
+// notification since tokens are still returned to the caller.
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// NotifierFunc allows an ordinary func to be used as a Notifier.
+type NotifierFunc func(n Notification) error
+
+func (f NotifierFunc) Notify(n Notification) error {
+	return f(n)
+}
+
+func notify(nr Notifier, n Notification) {
+	if nr == nil || n.To == "" {
+		return
+	}
+	if err := nr.Notify(n); err != nil {
+		LogErr(err)
+	}
+}
+
+// RecordingNotifier keeps every notification in memory, for use in tests.
+type RecordingNotifier struct {
+	mu            sync.Mutex
+	Notifications []Notification
+}
+
+func (r *RecordingNotifier) Notify(n Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Notifications = append(r.Notifications, n)
+	return nil
+}
+
+// Last returns the most recent notification of the given kind sent to an address or nil.
+func (r *RecordingNotifier) Last(kind NotificationKind, to string) *Notification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.Notifications) - 1; i >= 0; i-- {
+		if n := r.Notifications[i]; n.Kind == kind && n.To == to {
+			return &n
+		}
+	}
+	return nil
+}
+
+func (r *RecordingNotifier) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Notifications = nil
+}
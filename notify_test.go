@@ -0,0 +1,116 @@
+package gus
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// smtpStandIn accepts a single SMTP session and sends the DATA it receives on the returned channel.
+func smtpStandIn(t *testing.T) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	data := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250 localhost")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				b, _ := ioutil.ReadAll(tp.DotReader())
+				data <- string(b)
+				tp.PrintfLine("250 OK")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("250 OK")
+			}
+		}
+	}()
+	return l.Addr().String(), data
+}
+
+func TestSmtpNotifier(t *testing.T) {
+	addr, data := smtpStandIn(t)
+	sn := NewSmtpNotifier(addr, nil, "gus@mail.com")
+	err := sn.Notify(Notification{Kind: NotifyPasswordReset, To: "smtp@mail.com", User: &User{FirstName: "Jo"}, Token: "ABC123"})
+	assert.Nil(t, err)
+	msg := <-data
+	assert.Contains(t, msg, "To: smtp@mail.com")
+	assert.Contains(t, msg, "Subject: Reset your password")
+	assert.Contains(t, msg, "multipart/alternative")
+	assert.Contains(t, msg, "Hi Jo,")
+	assert.Contains(t, msg, "<code>ABC123</code>")
+}
+
+func TestMaildirNotifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gus-maildir")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	md, err := NewMaildirNotifier(dir, "gus@mail.com")
+	assert.Nil(t, err)
+	assert.Nil(t, md.Templates.Set(NotifyLockout, "Locked {{.To}}", "Locked out.", ""))
+	err = md.Notify(Notification{Kind: NotifyLockout, To: "maildir@mail.com"})
+	assert.Nil(t, err)
+	files, err := ioutil.ReadDir(filepath.Join(dir, "new"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+	f, err := os.Open(filepath.Join(dir, "new", files[0].Name()))
+	assert.Nil(t, err)
+	defer f.Close()
+	h, err := textproto.NewReader(bufio.NewReader(f)).ReadMIMEHeader()
+	assert.Nil(t, err)
+	assert.Equal(t, "Locked maildir@mail.com", h.Get("Subject"))
+	assert.Equal(t, "text/plain; charset=utf-8", h.Get("Content-Type"))
+}
+
+func TestUsers_Notify(t *testing.T) {
+	rec := &RecordingNotifier{}
+	us.Notifier = rec
+	defer func() { us.Notifier = nil }()
+
+	u, token, err := us.SignUp(SignUpParams{Email: "notify@mail.com"})
+	assert.Nil(t, err)
+	n := rec.Last(NotifyActivation, u.Email)
+	assert.NotNil(t, n)
+	assert.Equal(t, token, n.Token)
+
+	token, err = us.ResetPassword(ResetPasswordParams{Email: u.Email})
+	assert.Nil(t, err)
+	n = rec.Last(NotifyPasswordReset, u.Email)
+	assert.NotNil(t, n)
+	assert.Equal(t, token, n.Token)
+
+	token, err = us.RequestEmailChange(u.Id, "notify2@mail.com")
+	assert.Nil(t, err)
+	assert.Equal(t, token, rec.Last(NotifyEmailChangeConfirm, "notify2@mail.com").Token)
+	assert.Equal(t, "notify2@mail.com", rec.Last(NotifyEmailChangeNotice, u.Email).Data["new_email"])
+	_, err = us.ConfirmEmailChange(token)
+	assert.Nil(t, err)
+	assert.NotNil(t, rec.Last(NotifyEmailChanged, u.Email))
+
+	for i := int64(0); i <= us.AuthAttempts; i++ {
+		us.isLocked("notify2@mail.com")
+	}
+	assert.NotNil(t, rec.Last(NotifyLockout, "notify2@mail.com"))
+}
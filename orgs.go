@@ -13,13 +13,14 @@ var (
 type OrgType int64
 
 func NewOrgs(db *sql.DB) *Orgs {
-	return &Orgs{db: db, Suspender: NewSuspender("orgs", db)}
+	return &Orgs{db: db, Suspender: NewSuspender("orgs", db), Hooks: NewHooks()}
 }
 
 type Org struct {
-	Id   int64   `json:"id"`
-	Name string  `json:"name"`
-	Type OrgType `json:"type"`
+	Id       int64   `json:"id"`
+	ParentId int64   `json:"parent_id"`
+	Name     string  `json:"name"`
+	Type     OrgType `json:"type"`
 
 	Street   string `json:"street"`
 	Suburb   string `json:"suburb"`
@@ -30,16 +31,24 @@ type Org struct {
 	Updated   int64 `json:"updated"`
 	Created   int64 `json:"created"`
 	Suspended bool  `json:"suspended"`
+	Version   int64 `json:"version"` // Incremented by every change, see UpdateOrgParams.ExpectedVersion.
+	// Attributes are the org's own attributes and those it inherits, see OrgOpts.Inherit.
+	Attributes Attributes `json:"attributes,omitempty"`
 }
 
 type Orgs struct {
 	db *sql.DB
 	*Suspender
+	OrgOpts
+	*Hooks
+	audit AuditContext
+	guard func(q queryer) error // Runs first in the transaction of each operation, see guarded.
 }
 
 type CreateOrgParams struct {
-	Name string  `json:"name"`
-	Type OrgType `json:"type"`
+	ParentId int64   `json:"parent_id"`
+	Name     string  `json:"name"`
+	Type     OrgType `json:"type"`
 
 	Street   string `json:"street"`
 	Suburb   string `json:"suburb"`
@@ -47,6 +56,7 @@ type CreateOrgParams struct {
 	Postcode string `json:"postcode"`
 	Country  string `json:"country"`
 
+	Attributes      Attributes `json:"attributes"` // Checked against OrgOpts.Attributes.
 	CustomValidator `json:"-"`
 }
 
@@ -61,48 +71,81 @@ func (va CreateOrgParams) Validate() error {
 }
 
 func (us *Orgs) Create(p CreateOrgParams) (*Org, error) {
-	stmt, err := us.db.Prepare("INSERT INTO orgs(name, type, street, suburb, town, postcode , country, updated, created, deleted, suspended) values(?,?,?,?,?,?,?,?,?,?,?)")
+	attrs, err := us.Attributes.check(p.Attributes)
 	if err != nil {
 		return nil, err
 	}
-	u := &Org{Name: p.Name, Type: p.Type, Street: p.Street, Suburb: p.Suburb, Town: p.Town, Postcode: p.Postcode, Country: p.Country, Created: Milliseconds(time.Now()), Updated: Milliseconds(time.Now())}
-	res, err := stmt.Exec(u.Name, u.Type, u.Street, u.Suburb, u.Town, u.Postcode, u.Country, u.Updated, u.Created, 0, false)
-	if err != nil {
-		return nil, err
+	if p.ParentId > 0 {
+		if _, err := us.Get(p.ParentId); err != nil {
+			return nil, err
+		}
 	}
-	id, err := res.LastInsertId()
+	u := &Org{ParentId: p.ParentId, Name: p.Name, Type: p.Type, Street: p.Street, Suburb: p.Suburb, Town: p.Town, Postcode: p.Postcode, Country: p.Country, Created: Milliseconds(time.Now()), Updated: Milliseconds(time.Now())}
+	e := &Event{Kind: OrgCreated, Org: u}
+	err = us.emit(e, AuditOrgCreate, func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare("INSERT INTO orgs(parent_id, name, type, street, suburb, town, postcode , country, updated, created, deleted, suspended) values(?,?,?,?,?,?,?,?,?,?,?,?)")
+		if err != nil {
+			return err
+		}
+		res, err := stmt.Exec(u.ParentId, u.Name, u.Type, u.Street, u.Suburb, u.Town, u.Postcode, u.Country, u.Updated, u.Created, 0, false)
+		if err != nil {
+			return err
+		}
+		u.Id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		e.OrgId = u.Id
+		if err = orgAttrs.save(tx, u.Id, attrs); err != nil {
+			return err
+		}
+		return us.withAttributes(tx, u)
+	})
 	if err != nil {
 		return nil, err
 	}
-	u.Id = id
 	return u, nil
 }
 
 func (us *Orgs) Get(id int64) (*Org, error) {
-	stmt, err := us.db.Prepare("SELECT id, name, type, street, suburb, town, postcode, country, created, updated, suspended from orgs WHERE id = ? AND deleted = 0 LIMIT 1")
+	stmt, err := us.db.Prepare("SELECT " + orgCols + " from orgs WHERE id = ? AND deleted = 0 LIMIT 1")
 	if err != nil {
 		return nil, err
 	}
-	row := stmt.QueryRow(id)
-	var u Org
+	o, err := scanOrg(stmt.QueryRow(id))
+	if err != nil {
+		return nil, err
+	}
+	return o, us.withAttributes(us.db, o)
+}
+
+const orgCols = "id, COALESCE(parent_id, 0) AS parent_id, name, type, street, suburb, town, postcode, country, created, updated, suspended, version"
+
+func scanOrg(row scanner) (*Org, error) {
+	var o Org
 	var suspended int8
-	err = CheckNotFound(row.Scan(&u.Id, &u.Name, &u.Type, &u.Street, &u.Suburb, &u.Town, &u.Postcode, &u.Country,
-		&u.Created, &u.Updated, &suspended))
+	err := CheckNotFound(row.Scan(&o.Id, &o.ParentId, &o.Name, &o.Type, &o.Street, &o.Suburb, &o.Town, &o.Postcode, &o.Country,
+		&o.Created, &o.Updated, &suspended, &o.Version))
 	if err != nil {
 		return nil, err
 	}
-	u.Suspended = suspended > 0
-	return &u, err
+	o.Suspended = suspended > 0
+	return &o, nil
 }
 
 type UpdateOrgParams struct {
-	Id              *int64  `json:"id"`
-	Name            *string `json:"name"`
-	Street          *string `json:"street"`
-	Suburb          *string `json:"suburb"`
-	Town            *string `json:"town"`
-	Postcode        *string `json:"postcode"`
-	Country         *string `json:"country"`
+	Id         *int64     `json:"id"`
+	ParentId   *int64     `json:"parent_id"` // 0 makes the org a root org.
+	Name       *string    `json:"name"`
+	Street     *string    `json:"street"`
+	Suburb     *string    `json:"suburb"`
+	Town       *string    `json:"town"`
+	Postcode   *string    `json:"postcode"`
+	Country    *string    `json:"country"`
+	Attributes Attributes `json:"attributes"` // Only the attributes given are changed, nil values remove them.
+	// Optional, the version of the org the changes were made to. Update returns a ConflictError if the org has
+	// changed since.
+	ExpectedVersion *int64 `json:"expected_version"`
 	CustomValidator `json:"-"`
 }
 
@@ -116,21 +159,76 @@ func (va *UpdateOrgParams) Validate() error {
 	return nil
 }
 
+// Update changes the fields of the org which are given, see Patch.
 func (us *Orgs) Update(p UpdateOrgParams) error {
+	_, err := us.Patch(p)
+	return err
+}
+
+// Patch writes only the fields of p which are given and differ from the org's, a pointer to "" clears a field
+// and a ParentId of 0 makes the org a root org. It returns the changes keyed by field, attributes are keyed
+// "attributes.<name>", and writes nothing when there are none.
+func (us *Orgs) Patch(p UpdateOrgParams) (map[string]Change, error) {
+	if p.Id == nil {
+		return nil, ErrNotFound
+	}
+	if p.Name != nil && *p.Name == "" {
+		return nil, ErrNameRequired
+	}
 	o, err := us.Get(*p.Id)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if p.ExpectedVersion != nil && *p.ExpectedVersion != o.Version {
+		return nil, &ConflictError{Version: o.Version}
 	}
-	ApplyUpdates(o, p)
-	stmt, err := us.db.Prepare("UPDATE orgs SET name = ?, street = ?, suburb = ?, town = ?, postcode = ?, country = ?, updated = ? WHERE id = ? AND deleted = 0")
+	attrs, err := us.Attributes.check(p.Attributes)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = CheckUpdated(stmt.Exec(o.Name, o.Street, o.Suburb, o.Town, o.Postcode, o.Country, Milliseconds(time.Now()), o.Id))
+	pa := newPatch("orgs", o.Id)
+	pa.int("parent_id", &o.ParentId, p.ParentId)
+	pa.str("name", &o.Name, p.Name)
+	pa.str("street", &o.Street, p.Street)
+	pa.str("suburb", &o.Suburb, p.Suburb)
+	pa.str("town", &o.Town, p.Town)
+	pa.str("postcode", &o.Postcode, p.Postcode)
+	pa.str("country", &o.Country, p.Country)
+	if len(attrs) > 0 {
+		// Compare with the org's own attributes so an inherited value can be pinned.
+		own, err := orgAttrs.load(us.db, us.Attributes, o.Id)
+		if err != nil {
+			return nil, err
+		}
+		pa.attributes(own[o.Id], attrs)
+	}
+	if pa.empty() {
+		return pa.changes, nil
+	}
+	err = us.emit(&Event{Kind: OrgUpdated, OrgId: o.Id, Org: o, Changes: pa.changes}, AuditOrgUpdate, func(tx *sql.Tx) error {
+		if _, ok := pa.changes["parent_id"]; ok {
+			if err := checkParent(tx, o.Id, o.ParentId); err != nil {
+				return err
+			}
+		}
+		if err := pa.exec(tx, p.ExpectedVersion); err != nil {
+			return err
+		}
+		o.Version++
+		if err := orgAttrs.save(tx, o.Id, pa.attrs); err != nil {
+			return err
+		}
+		return us.withAttributes(tx, o)
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return pa.changes, nil
+}
+
+// SetAttribute sets one of the org's attributes, a nil value removes it.
+func (us *Orgs) SetAttribute(id int64, name string, value interface{}) error {
+	return us.Update(UpdateOrgParams{Id: &id, Attributes: Attributes{name: value}})
 }
 
 type ListOrgsParams struct {
@@ -139,13 +237,15 @@ type ListOrgsParams struct {
 	OrgFilters
 }
 type OrgFilters struct {
-	Name      string `schema:"name"`
-	Type      int64  `schema:"type"`
-	Street    string `schema:"street"` // sort by org name
-	Suburb    string `schema:"suburb"`
-	Town      string `schema:"town"`
-	Postcode  string `schema:"postcode"`
-	Suspended *bool  `schema:"suspended"`
+	Under     int64   `schema:"under"` // The org and all of its descendants.
+	Name      string  `schema:"name"`
+	Type      int64   `schema:"type"`
+	Street    string  `schema:"street"` // sort by org name
+	Suburb    string  `schema:"suburb"`
+	Town      string  `schema:"town"`
+	Postcode  string  `schema:"postcode"`
+	Suspended *bool   `schema:"suspended"`
+	Where     *Filter `schema:"-" json:"where"` // Conditions on OrgFilterFields.
 }
 
 func (va *ListOrgsParams) Validate() error {
@@ -157,12 +257,13 @@ func (va *ListOrgsParams) Validate() error {
 
 type OrgListResponse struct {
 	ListArgs
+	Cursors
 	Total int64  `json:"total"`
 	Items []*Org `json:"items"`
 }
 
 func (us *Orgs) List(p ListOrgsParams) (*OrgListResponse, error) {
-	q := "SELECT id, name, type, street, suburb, town, postcode, country, created, updated, suspended from orgs WHERE 1"
+	q := "SELECT " + orgCols + " from orgs WHERE 1"
 	countq := "SELECT count(id) FROM orgs WHERE 1"
 
 	args := []interface{}{}
@@ -170,6 +271,13 @@ func (us *Orgs) List(p ListOrgsParams) (*OrgListResponse, error) {
 		q += " AND deleted = 0"
 		countq += " AND deleted = 0"
 	}
+	if p.Under > 0 {
+		ids, err := subtreeIds(us.db, p.Under)
+		if err != nil {
+			return nil, err
+		}
+		q, countq, args = addInClause(q, countq, " AND id IN ", args, ids)
+	}
 	if p.Name != "" {
 		q, countq, args = addClause(q, countq, " AND name like ?", args, "%"+p.Name+"%")
 	}
@@ -197,38 +305,56 @@ func (us *Orgs) List(p ListOrgsParams) (*OrgListResponse, error) {
 			countq += " AND suspended = 0"
 		}
 	}
-
-	rows, err := GetRows(us.db, q, &p.ListArgs, args...)
+	q, countq, args, err := addFilter(q, countq, args, p.Where, orgAttrs.filterFields(OrgFilterFields, us.Attributes, "orgs.id"))
 	if err != nil {
 		return nil, err
 	}
-	row := us.db.QueryRow(countq, args...)
-	var total int64
-	err = row.Scan(&total)
+
+	rows, err := GetPage(us.db, q, OrgSortFields, &p.ListArgs, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+	total := int64(-1)
+	if !p.SkipCount {
+		err = us.db.QueryRow(countq, args...).Scan(&total)
+		if err != nil {
+			return nil, err
+		}
+	}
 	ogs := []*Org{}
 	for rows.Next() {
-		u := &Org{}
-		var suspended int
-		rows.Scan(&u.Id, &u.Name, &u.Type, &u.Street, &u.Suburb, &u.Town, &u.Postcode, &u.Country,
-			&u.Created, &u.Updated, &suspended)
-		u.Suspended = suspended > 0
+		u, err := scanOrg(rows)
+		if err != nil {
+			return nil, err
+		}
 		ogs = append(ogs, u)
 	}
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
+	if err = us.withAttributes(us.db, ogs...); err != nil {
+		return nil, err
+	}
+	var cs Cursors
+	if len(ogs) > 0 {
+		cs, err = pageCursors(p.ListArgs, OrgSortFields, ogs[0], ogs[len(ogs)-1], len(ogs))
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return &OrgListResponse{
-		Total: total,
-		Items: ogs,
+		Total:   total,
+		Items:   ogs,
+		Cursors: cs,
 		ListArgs: ListArgs{
 			Size:      p.Size,
 			Page:      p.Page,
 			Direction: p.Direction,
 			OrderBy:   p.OrderBy,
 			Deleted:   p.Deleted,
+			Cursor:    p.Cursor,
+			SkipCount: p.SkipCount,
 		}}, nil
 }
@@ -22,9 +22,9 @@ func TestOrgs_Create(t *testing.T) {
 	assert.Equal(t, u.Name, corg.Name)
 	assert.Equal(t, u.Suburb, corg.Suburb)
 
-	orgs, err := orgsv.List(ListOrgsParams{})
+	orgs, err := orgsv.List(ListOrgsParams{ListArgs: ListArgs{OrderBy: "id", Direction: DirectionDesc}})
 	assert.Nil(t, err)
-	assert.Equal(t, 1, len(orgs.Items))
+	assert.Equal(t, u.Id, orgs.Items[0].Id)
 	assert.Equal(t, corg.Name, orgs.Items[0].Name)
 }
 
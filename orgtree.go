@@ -0,0 +1,130 @@
+package gus
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// maxOrgDepth guards against walking a corrupt hierarchy forever.
+const maxOrgDepth = 100
+
+var (
+	ErrOrgCycle = ErrInvalid("An org can't be moved under itself or one of its descendants.")
+)
+
+// Children returns the orgs directly under the org.
+func (us *Orgs) Children(id int64) ([]*Org, error) {
+	rows, err := us.db.Query("SELECT "+orgCols+" FROM orgs WHERE parent_id = ? AND deleted = 0 ORDER BY name", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	children := []*Org{}
+	for rows.Next() {
+		o, err := scanOrg(rows)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, o)
+	}
+	return children, rows.Err()
+}
+
+// Ancestors returns the org's parent, its parent and so on up to the root org.
+func (us *Orgs) Ancestors(id int64) ([]*Org, error) {
+	o, err := us.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	ancestors := []*Org{}
+	for i := 0; o.ParentId > 0 && i < maxOrgDepth; i++ {
+		o, err = us.Get(o.ParentId)
+		if err != nil {
+			if _, ok := err.(*NotFoundError); ok {
+				break
+			}
+			return nil, err
+		}
+		ancestors = append(ancestors, o)
+	}
+	return ancestors, nil
+}
+
+// checkParent returns ErrOrgCycle if parentId is the org or one of its descendants, it's run in the transaction
+// which moves the org so concurrent moves can't create a cycle.
+func checkParent(q queryer, id int64, parentId int64) error {
+	if parentId == 0 {
+		return nil
+	}
+	var count int64
+	if err := q.QueryRow("SELECT count(id) FROM orgs WHERE id = ? AND deleted = 0", parentId).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	ids, err := subtreeIds(q, id)
+	if err != nil {
+		return err
+	}
+	for _, v := range ids {
+		if v == parentId {
+			return ErrOrgCycle
+		}
+	}
+	return nil
+}
+
+// subtreeIds returns the id of the org followed by the ids of all of its descendants.
+func subtreeIds(q queryer, id int64) ([]int64, error) {
+	ids := []int64{id}
+	level := []int64{id}
+	for depth := 0; len(level) > 0 && depth < maxOrgDepth; depth++ {
+		args := make([]interface{}, len(level))
+		for i, v := range level {
+			args[i] = v
+		}
+		rows, err := q.Query("SELECT id FROM orgs WHERE deleted = 0 AND parent_id IN ("+
+			strings.TrimSuffix(strings.Repeat("?,", len(level)), ",")+")", args...)
+		if err != nil {
+			return nil, err
+		}
+		next := []int64{}
+		for rows.Next() {
+			var child int64
+			if err = rows.Scan(&child); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			next = append(next, child)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		ids = append(ids, next...)
+		level = next
+	}
+	return ids, nil
+}
+
+// ancestorSuspended returns true if any org above the org is suspended or deleted.
+func ancestorSuspended(q queryer, id int64) (bool, error) {
+	for i := 0; i < maxOrgDepth; i++ {
+		var parentId int64
+		var suspended int
+		err := q.QueryRow("SELECT p.id, CASE WHEN p.suspended = 1 OR p.deleted = 1 THEN 1 ELSE 0 END FROM orgs o "+
+			"JOIN orgs p ON o.parent_id = p.id WHERE o.id = ?", id).Scan(&parentId, &suspended)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if suspended > 0 {
+			return true, nil
+		}
+		id = parentId
+	}
+	return false, nil
+}
@@ -0,0 +1,64 @@
+package gus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestOrgs_Hierarchy(t *testing.T) {
+	parent, err := orgsv.Create(CreateOrgParams{Name: "Parent Co."})
+	assert.Nil(t, err)
+	dept, err := orgsv.Create(CreateOrgParams{Name: "Department", ParentId: parent.Id})
+	assert.Nil(t, err)
+	team, err := orgsv.Create(CreateOrgParams{Name: "Team", ParentId: dept.Id})
+	assert.Nil(t, err)
+	_, err = orgsv.Create(CreateOrgParams{Name: "Orphan", ParentId: 33453453})
+	assert.Equal(t, ErrNotFound, err)
+
+	children, err := orgsv.Children(parent.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(children))
+	assert.Equal(t, dept.Id, children[0].Id)
+	ancestors, err := orgsv.Ancestors(team.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ancestors))
+	assert.Equal(t, dept.Id, ancestors[0].Id)
+	assert.Equal(t, parent.Id, ancestors[1].Id)
+	orgs, err := orgsv.List(ListOrgsParams{OrgFilters: OrgFilters{Under: parent.Id}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), orgs.Total)
+
+	// Cycles
+	assert.Equal(t, ErrOrgCycle, orgsv.Update(UpdateOrgParams{Id: &parent.Id, ParentId: &team.Id}))
+	assert.Equal(t, ErrOrgCycle, orgsv.Update(UpdateOrgParams{Id: &dept.Id, ParentId: &dept.Id}))
+
+	password := "M0nk3yNutz5"
+	_, _, err = us.SignUp(SignUpParams{Email: "team-member@mail.com", Password: password, OrgId: team.Id})
+	assert.Nil(t, err)
+	_, _, err = us.SignUp(SignUpParams{Email: "dept-member@mail.com", Password: password, OrgId: dept.Id})
+	assert.Nil(t, err)
+	users, err := us.List(ListUsersParams{UserFilters: UserFilters{UnderOrgId: parent.Id}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), users.Total)
+	users, err = us.List(ListUsersParams{UserFilters: UserFilters{UnderOrgId: team.Id}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), users.Total)
+
+	// Suspending a parent suspends descendants
+	assert.Nil(t, orgsv.Suspend(parent.Id))
+	uc, _, err := us.GetByUsername("team-member@mail.com")
+	assert.Nil(t, err)
+	assert.True(t, uc.OrgSuspended)
+	_, err = us.SignIn(SignInParams{Email: "team-member@mail.com", Password: password})
+	assert.Equal(t, ErrNotAuth, err)
+	assert.Nil(t, orgsv.Restore(parent.Id))
+	_, err = us.SignIn(SignInParams{Email: "team-member@mail.com", Password: password})
+	assert.Nil(t, err)
+
+	// Move to root
+	root := int64(0)
+	assert.Nil(t, orgsv.Update(UpdateOrgParams{Id: &team.Id, ParentId: &root}))
+	ancestors, err = orgsv.Ancestors(team.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(ancestors))
+}
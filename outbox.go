@@ -0,0 +1,437 @@
+package gus
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/asaskevich/govalidator"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUrlInvalid     = ErrInvalid("'url' must be an http or https url.")
+	ErrSecretRequired = ErrInvalid("'secret' required.")
+)
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryDead      DeliveryStatus = "dead" // MaxAttempts failed, see DeadLetters and Replay.
+)
+
+const (
+	HeaderEvent     = "X-Gus-Event"
+	HeaderDelivery  = "X-Gus-Delivery"
+	HeaderSignature = "X-Gus-Signature" // "sha256=" followed by the hex HMAC-SHA256 of the body keyed by the webhook secret.
+)
+
+type OutboxOpts struct {
+	MaxAttempts   int64         // Attempts before a delivery is dead-lettered, default 10.
+	RetryDelay    time.Duration // Delay before the first retry which doubles with each attempt, default 10 seconds.
+	MaxRetryDelay time.Duration // Default 1 hour.
+	BatchSize     int64         // Events and deliveries handled by each Dispatch, default 100.
+	Client        *http.Client  // Default has a 10 second timeout.
+}
+
+func NewOutbox(db *sql.DB, opts OutboxOpts) *Outbox {
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = 10
+	}
+	if opts.RetryDelay == 0 {
+		opts.RetryDelay = 10 * time.Second
+	}
+	if opts.MaxRetryDelay == 0 {
+		opts.MaxRetryDelay = time.Hour
+	}
+	if opts.BatchSize == 0 {
+		opts.BatchSize = 100
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Outbox{db: db, OutboxOpts: opts}
+}
+
+// Outbox holds events written in the same transaction as the change which caused them, so they survive a crash
+// after commit, and delivers them to webhooks. Delivery is at least once, receivers should use the payload id to
+// ignore duplicates.
+type Outbox struct {
+	db *sql.DB
+	OutboxOpts
+}
+
+type Webhook struct {
+	Id      int64       `json:"id"`
+	Url     string      `json:"url"`
+	Secret  string      `json:"-"`
+	Kinds   []EventKind `json:"kinds"` // Empty for every kind.
+	Created int64       `json:"created"`
+	Updated int64       `json:"updated"`
+}
+
+func (w *Webhook) wants(kind EventKind) bool {
+	if len(w.Kinds) == 0 {
+		return true
+	}
+	for _, k := range w.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookPayload is the body posted to webhooks, Id is the same for every delivery of an event.
+type WebhookPayload struct {
+	Id    int64 `json:"id"`
+	Event Event `json:"event"`
+}
+
+type Delivery struct {
+	Id          int64          `json:"id"`
+	OutboxId    int64          `json:"outbox_id"`
+	WebhookId   int64          `json:"webhook_id"`
+	Kind        EventKind      `json:"kind"`
+	Status      DeliveryStatus `json:"status"`
+	Attempts    int64          `json:"attempts"`
+	NextAttempt int64          `json:"next_attempt"`
+	LastError   string         `json:"last_error"`
+	Created     int64          `json:"created"`
+	Updated     int64          `json:"updated"`
+}
+
+func (ob *Outbox) add(tx *sql.Tx, e Event) error {
+	if e.Created == 0 {
+		e.Created = Milliseconds(time.Now())
+	}
+	p, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO outbox (kind, user_id, payload, created, fanned_out) values (?, ?, ?, ?, ?)",
+		e.Kind, e.UserId, string(p), e.Created, 0)
+	return err
+}
+
+type CreateWebhookParams struct {
+	Url             string      `json:"url"`
+	Secret          string      `json:"secret"`
+	Kinds           []EventKind `json:"kinds"`
+	CustomValidator `json:"-"`
+}
+
+func (va *CreateWebhookParams) Validate() error {
+	if va.CustomValidator != nil {
+		return va.CustomValidator()
+	}
+	if !govalidator.IsURL(va.Url) || !(strings.HasPrefix(va.Url, "http://") || strings.HasPrefix(va.Url, "https://")) {
+		return ErrUrlInvalid
+	}
+	if govalidator.IsNull(va.Secret) {
+		return ErrSecretRequired
+	}
+	return nil
+}
+
+// AddWebhook registers an endpoint which receives events added to the outbox from now on.
+func (ob *Outbox) AddWebhook(p CreateWebhookParams) (*Webhook, error) {
+	w := &Webhook{Url: p.Url, Secret: p.Secret, Kinds: p.Kinds, Created: Milliseconds(time.Now()), Updated: Milliseconds(time.Now())}
+	if w.Kinds == nil {
+		w.Kinds = []EventKind{}
+	}
+	res, err := ob.db.Exec("INSERT INTO webhooks (url, secret, kinds, created, updated, deleted) values (?, ?, ?, ?, ?, ?)",
+		w.Url, w.Secret, joinKinds(w.Kinds), w.Created, w.Updated, 0)
+	if err != nil {
+		return nil, err
+	}
+	w.Id, err = res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// RemoveWebhook stops deliveries to the webhook including those pending.
+func (ob *Outbox) RemoveWebhook(id int64) error {
+	return CheckUpdated(ob.db.Exec("UPDATE webhooks SET deleted = 1, updated = ? WHERE id = ? AND deleted = 0", Milliseconds(time.Now()), id))
+}
+
+func (ob *Outbox) Webhooks() ([]*Webhook, error) {
+	return webhooks(ob.db)
+}
+
+func webhooks(q queryer) ([]*Webhook, error) {
+	rows, err := q.Query("SELECT id, url, secret, kinds, created, updated FROM webhooks WHERE deleted = 0 ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*Webhook{}
+	for rows.Next() {
+		var w Webhook
+		var kinds string
+		if err = rows.Scan(&w.Id, &w.Url, &w.Secret, &kinds, &w.Created, &w.Updated); err != nil {
+			return nil, err
+		}
+		w.Kinds = splitKinds(kinds)
+		items = append(items, &w)
+	}
+	return items, rows.Err()
+}
+
+// Run calls Dispatch every interval until stop is closed.
+func (ob *Outbox) Run(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if _, err := ob.Dispatch(); err != nil {
+			LogErr(err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Dispatch makes a delivery for each webhook which wants a new event then attempts the deliveries which are due,
+// it returns the number delivered.
+func (ob *Outbox) Dispatch() (int, error) {
+	if err := ob.fanOut(); err != nil {
+		return 0, err
+	}
+	rows, err := ob.db.Query("SELECT d.id, o.id, o.payload, w.url, w.secret, d.attempts FROM webhook_deliveries d "+
+		"JOIN outbox o ON d.outbox_id = o.id JOIN webhooks w ON d.webhook_id = w.id "+
+		"WHERE d.status = ? AND d.next_attempt <= ? AND w.deleted = 0 ORDER BY d.id LIMIT ?",
+		DeliveryPending, Milliseconds(time.Now()), ob.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	type due struct {
+		id, outboxId, attempts int64
+		payload, url, secret   string
+	}
+	dues := []due{}
+	for rows.Next() {
+		var d due
+		if err = rows.Scan(&d.id, &d.outboxId, &d.payload, &d.url, &d.secret, &d.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		dues = append(dues, d)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	delivered := 0
+	for _, d := range dues {
+		var e Event
+		if err = json.Unmarshal([]byte(d.payload), &e); err != nil {
+			// Retrying can't fix the payload, so it's dead-lettered rather than holding up the deliveries after it.
+			if err = ob.unreadable(d.id, err); err != nil {
+				return delivered, err
+			}
+			continue
+		}
+		postErr := ob.post(d.url, d.secret, d.id, WebhookPayload{Id: d.outboxId, Event: e})
+		if err = ob.attempted(d.id, d.attempts+1, postErr); err != nil {
+			return delivered, err
+		}
+		if postErr == nil {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+// fanOut creates the deliveries of events which haven't been seen by Dispatch.
+func (ob *Outbox) fanOut() error {
+	return Tx(ob.db, func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT id, kind FROM outbox WHERE fanned_out = 0 ORDER BY id LIMIT ?", ob.BatchSize)
+		if err != nil {
+			return err
+		}
+		type pending struct {
+			id   int64
+			kind EventKind
+		}
+		events := []pending{}
+		for rows.Next() {
+			var p pending
+			if err = rows.Scan(&p.id, &p.kind); err != nil {
+				rows.Close()
+				return err
+			}
+			events = append(events, p)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil || len(events) == 0 {
+			return err
+		}
+		ws, err := webhooks(tx)
+		if err != nil {
+			return err
+		}
+		now := Milliseconds(time.Now())
+		for _, p := range events {
+			for _, w := range ws {
+				if !w.wants(p.kind) {
+					continue
+				}
+				_, err = tx.Exec("INSERT INTO webhook_deliveries (outbox_id, webhook_id, status, attempts, next_attempt, last_error, created, updated) "+
+					"values (?, ?, ?, ?, ?, ?, ?, ?)", p.id, w.Id, DeliveryPending, 0, now, "", now, now)
+				if err != nil {
+					return err
+				}
+			}
+			_, err = tx.Exec("UPDATE outbox SET fanned_out = 1 WHERE id = ?", p.id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ob *Outbox) post(url string, secret string, deliveryId int64, p WebhookPayload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, string(p.Event.Kind))
+	req.Header.Set(HeaderDelivery, fmt.Sprint(deliveryId))
+	req.Header.Set(HeaderSignature, Sign(secret, body))
+	res, err := ob.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", res.Status)
+	}
+	return nil
+}
+
+// attempted records the outcome of a delivery attempt, failures are retried with exponential backoff until
+// MaxAttempts when the delivery is dead-lettered.
+func (ob *Outbox) attempted(id int64, attempts int64, postErr error) error {
+	now := Milliseconds(time.Now())
+	if postErr == nil {
+		_, err := ob.db.Exec("UPDATE webhook_deliveries SET status = ?, attempts = ?, last_error = ?, updated = ? WHERE id = ?",
+			DeliveryDelivered, attempts, "", now, id)
+		return err
+	}
+	status := DeliveryPending
+	if attempts >= ob.MaxAttempts {
+		status = DeliveryDead
+	}
+	_, err := ob.db.Exec("UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt = ?, last_error = ?, updated = ? WHERE id = ?",
+		status, attempts, now+int64(ob.backoff(attempts)/time.Millisecond), postErr.Error(), now, id)
+	return err
+}
+
+// unreadable dead-letters a delivery whose payload can't be decoded without attempting it.
+func (ob *Outbox) unreadable(id int64, decodeErr error) error {
+	_, err := ob.db.Exec("UPDATE webhook_deliveries SET status = ?, last_error = ?, updated = ? WHERE id = ?",
+		DeliveryDead, decodeErr.Error(), Milliseconds(time.Now()), id)
+	return err
+}
+
+func (ob *Outbox) backoff(attempts int64) time.Duration {
+	d := ob.RetryDelay
+	for i := int64(1); i < attempts && d < ob.MaxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > ob.MaxRetryDelay {
+		d = ob.MaxRetryDelay
+	}
+	return d
+}
+
+// DeadLetters lists the deliveries which failed MaxAttempts times, most recent first.
+func (ob *Outbox) DeadLetters() ([]*Delivery, error) {
+	return ob.deliveries("d.status = ?", DeliveryDead)
+}
+
+// Deliveries lists the deliveries of an event.
+func (ob *Outbox) Deliveries(outboxId int64) ([]*Delivery, error) {
+	return ob.deliveries("d.outbox_id = ?", outboxId)
+}
+
+// Replay makes a dead or delivered delivery pending again, it's attempted by the next Dispatch.
+func (ob *Outbox) Replay(deliveryId int64) error {
+	now := Milliseconds(time.Now())
+	return CheckUpdated(ob.db.Exec("UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt = ?, updated = ? WHERE id = ? AND status <> ?",
+		DeliveryPending, now, now, deliveryId, DeliveryPending))
+}
+
+const deliveryCols = "d.id, d.outbox_id, d.webhook_id, o.kind, d.status, d.attempts, d.next_attempt, d.last_error, d.created, d.updated"
+
+func (ob *Outbox) deliveries(where string, arg interface{}) ([]*Delivery, error) {
+	rows, err := ob.db.Query("SELECT "+deliveryCols+" FROM webhook_deliveries d JOIN outbox o ON d.outbox_id = o.id "+
+		"WHERE "+where+" ORDER BY d.updated DESC, d.id DESC", arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*Delivery{}
+	for rows.Next() {
+		var d Delivery
+		var lastError sql.NullString
+		err = rows.Scan(&d.Id, &d.OutboxId, &d.WebhookId, &d.Kind, &d.Status, &d.Attempts, &d.NextAttempt, &lastError, &d.Created, &d.Updated)
+		if err != nil {
+			return nil, err
+		}
+		d.LastError = lastError.String
+		items = append(items, &d)
+	}
+	return items, rows.Err()
+}
+
+// Sign returns the value of the HeaderSignature header for a body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature is for webhook receivers to check the body was sent by gus.
+func VerifySignature(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+func joinKinds(kinds []EventKind) string {
+	s := make([]string, len(kinds))
+	for i, k := range kinds {
+		s[i] = string(k)
+	}
+	return strings.Join(s, ",")
+}
+
+func splitKinds(s string) []EventKind {
+	kinds := []EventKind{}
+	for _, k := range strings.Split(s, ",") {
+		if k != "" {
+			kinds = append(kinds, EventKind(k))
+		}
+	}
+	return kinds
+}
@@ -0,0 +1,128 @@
+package gus
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOutbox_Webhooks(t *testing.T) {
+	password := "M0nk3yNutz5"
+	ob := NewOutbox(us.db, OutboxOpts{MaxAttempts: 2, RetryDelay: time.Millisecond})
+	uo := us.By(0, "")
+	uo.Outbox = ob
+
+	received := make(chan WebhookPayload, 10)
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !VerifySignature("good-secret", body, r.Header.Get(HeaderSignature)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var p WebhookPayload
+		assert.Nil(t, json.Unmarshal(body, &p))
+		assert.Equal(t, string(p.Event.Kind), r.Header.Get(HeaderEvent))
+		received <- p
+	}))
+	defer good.Close()
+	failing := true
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer flaky.Close()
+	// Events left by other tests aren't delivered to these webhooks
+	_, err := us.db.Exec("UPDATE outbox SET fanned_out = 1")
+	assert.Nil(t, err)
+	gw, err := ob.AddWebhook(CreateWebhookParams{Url: good.URL, Secret: "good-secret", Kinds: []EventKind{UserCreated}})
+	assert.Nil(t, err)
+	defer ob.RemoveWebhook(gw.Id)
+	fw, err := ob.AddWebhook(CreateWebhookParams{Url: flaky.URL, Secret: "flaky-secret"})
+	assert.Nil(t, err)
+	defer ob.RemoveWebhook(fw.Id)
+
+	u, _, err := uo.SignUp(SignUpParams{Email: "outbox@mail.com", Password: password})
+	assert.Nil(t, err)
+	// Failed changes don't reach the outbox
+	_, _, err = uo.SignUp(SignUpParams{Email: "outbox@mail.com", Password: password})
+	assert.Equal(t, ErrEmailTaken, err)
+
+	n, err := ob.Dispatch()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	p := <-received
+	assert.Equal(t, UserCreated, p.Event.Kind)
+	assert.Equal(t, u.Id, p.Event.UserId)
+
+	// Retried then dead-lettered
+	time.Sleep(5 * time.Millisecond)
+	n, err = ob.Dispatch()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	dead, err := ob.DeadLetters()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(dead))
+	assert.Equal(t, int64(2), dead[0].Attempts)
+	assert.Contains(t, dead[0].LastError, "500")
+
+	failing = false
+	assert.Nil(t, ob.Replay(dead[0].Id))
+	n, err = ob.Dispatch()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	ds, err := ob.Deliveries(p.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ds))
+	for _, d := range ds {
+		assert.Equal(t, DeliveryDelivered, d.Status)
+	}
+	assert.Equal(t, 0, len(received))
+}
+
+func TestOutbox_CorruptPayload(t *testing.T) {
+	ob := NewOutbox(us.db, OutboxOpts{})
+	uo := us.By(0, "")
+	uo.Outbox = ob
+	received := make(chan WebhookPayload, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p WebhookPayload
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Nil(t, json.Unmarshal(body, &p))
+		received <- p
+	}))
+	defer srv.Close()
+	_, err := us.db.Exec("UPDATE outbox SET fanned_out = 1")
+	assert.Nil(t, err)
+	w, err := ob.AddWebhook(CreateWebhookParams{Url: srv.URL, Secret: "corrupt-secret"})
+	assert.Nil(t, err)
+	defer ob.RemoveWebhook(w.Id)
+
+	res, err := us.db.Exec("INSERT INTO outbox (kind, payload, created, fanned_out) values (?, ?, ?, ?)",
+		UserCreated, "{not json", Milliseconds(time.Now()), 0)
+	assert.Nil(t, err)
+	corruptId, err := res.LastInsertId()
+	assert.Nil(t, err)
+	u, _, err := uo.SignUp(SignUpParams{Email: "outbox-corrupt@mail.com", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+
+	// The corrupt event is dead-lettered and the one after it still delivered.
+	n, err := ob.Dispatch()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	p := <-received
+	assert.Equal(t, u.Id, p.Event.UserId)
+	ds, err := ob.Deliveries(corruptId)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(ds)) {
+		assert.Equal(t, DeliveryDead, ds[0].Status)
+		assert.NotEmpty(t, ds[0].LastError)
+	}
+	// Other tests count the dead letters.
+	_, err = us.db.Exec("DELETE FROM webhook_deliveries WHERE outbox_id = ?", corruptId)
+	assert.Nil(t, err)
+}
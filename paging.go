@@ -0,0 +1,187 @@
+package gus
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrCursorInvalid  = ErrInvalid("'cursor' is invalid.")
+	ErrCursorMismatch = ErrInvalid("'cursor' was made with a different sort order.")
+)
+
+// Cursors are returned by lists which support keyset pagination. Pass one as ListArgs.Cursor to get the next or
+// previous page, they are empty when there are no more results in that direction.
+type Cursors struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// cursor is the position of a row in a list, it's opaque to clients.
+type cursor struct {
+	OrderBy   string        `json:"o"`
+	Direction SortDir       `json:"d"`
+	Values    []interface{} `json:"v"`
+	Id        int64         `json:"i"`
+	Backward  bool          `json:"b,omitempty"`
+}
+
+func (c cursor) encode() string {
+	p, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(p)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	p, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrCursorInvalid
+	}
+	var c cursor
+	d := json.NewDecoder(bytes.NewReader(p))
+	d.UseNumber()
+	if err = d.Decode(&c); err != nil {
+		return nil, ErrCursorInvalid
+	}
+	for i, v := range c.Values {
+		if n, ok := v.(json.Number); ok {
+			if iv, err := n.Int64(); err == nil {
+				c.Values[i] = iv
+			} else if f, err := n.Float64(); err == nil {
+				c.Values[i] = f
+			}
+		} else if b, ok := v.(bool); ok {
+			c.Values[i] = 0
+			if b {
+				c.Values[i] = 1
+			}
+		}
+	}
+	return &c, nil
+}
+
+// GetPage is like GetRows but pages with lp.Cursor when one is given, sorting by the id after the sort keys so
+// the order is stable. The query is sorted and limited as it is so indexes on the sort keys can be used, it must
+// end with its WHERE clause which the cursor's conditions are ANDed to. The columns of sortable are expressions of
+// the query and its fields the names of its result columns.
+func GetPage(db *sql.DB, query string, sortable SortFields, lp *ListArgs, args ...interface{}) (*sql.Rows, error) {
+	keys, err := lp.SortKeys(sortable)
+	if err != nil {
+		return nil, err
+	}
+	// The id breaks ties in the direction of the last key.
+	keys = append(keys, SortKey{Field: "id", Column: sortable["id"], Direction: keys[len(keys)-1].Direction})
+	if lp.Cursor == "" {
+		query += fmt.Sprintf(" ORDER BY %s LIMIT ? OFFSET ?", orderBy(keys, "", false))
+		args = append(args, lp.Size, lp.Page*lp.Size)
+		return db.Query(query, args...)
+	}
+	c, err := decodeCursor(lp.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if c.OrderBy != lp.OrderBy || c.Direction != lp.Direction || len(c.Values) != len(keys)-1 {
+		return nil, ErrCursorMismatch
+	}
+	values := append(c.Values, c.Id)
+	// Rows after the cursor in the direction of travel, compared key by key.
+	ors := []string{}
+	for i, k := range keys {
+		ands := []string{}
+		for j := 0; j < i; j++ {
+			ands = append(ands, keys[j].Column+" = ?")
+			args = append(args, values[j])
+		}
+		dir := k.Direction
+		if c.Backward {
+			dir = reverse(dir)
+		}
+		cmp := ">"
+		if dir == DirectionDesc {
+			cmp = "<"
+		}
+		ands = append(ands, k.Column+" "+cmp+" ?")
+		args = append(args, values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	query += fmt.Sprintf(" AND (%s) ORDER BY %s LIMIT ?", strings.Join(ors, " OR "), orderBy(keys, "", c.Backward))
+	args = append(args, lp.Size)
+	if c.Backward {
+		// Put the page, at most lp.Size rows, back in the requested order.
+		byField := make([]SortKey, len(keys))
+		for i, k := range keys {
+			byField[i] = SortKey{Field: k.Field, Column: k.Field, Direction: k.Direction}
+		}
+		query = fmt.Sprintf("SELECT * FROM (%s) p ORDER BY %s", query, orderBy(byField, "p", false))
+	}
+	return db.Query(query, args...)
+}
+
+func reverse(d SortDir) SortDir {
+	if d == DirectionAsc {
+		return DirectionDesc
+	}
+	return DirectionAsc
+}
+
+// pageCursors returns the cursors either side of a page of items, the items must be json objects with an id and
+// the fields sorted by.
+func pageCursors(lp ListArgs, sortable SortFields, first interface{}, last interface{}, count int) (Cursors, error) {
+	var cs Cursors
+	if count == 0 {
+		return cs, nil
+	}
+	keys, err := lp.SortKeys(sortable)
+	if err != nil {
+		return cs, err
+	}
+	var backward bool
+	if lp.Cursor != "" {
+		c, err := decodeCursor(lp.Cursor)
+		if err != nil {
+			return cs, err
+		}
+		backward = c.Backward
+	}
+	full := count >= lp.Size
+	if backward || full {
+		if cs.NextCursor, err = cursorAt(lp, keys, last, false); err != nil {
+			return cs, err
+		}
+	}
+	if (backward && full) || (!backward && (lp.Cursor != "" || lp.Page > 0)) {
+		if cs.PrevCursor, err = cursorAt(lp, keys, first, true); err != nil {
+			return cs, err
+		}
+	}
+	return cs, nil
+}
+
+func cursorAt(lp ListArgs, keys []SortKey, item interface{}, backward bool) (string, error) {
+	p, err := json.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+	var fields map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(p))
+	d.UseNumber()
+	if err = d.Decode(&fields); err != nil {
+		return "", err
+	}
+	n, ok := fields["id"].(json.Number)
+	if !ok {
+		return "", sqlErr
+	}
+	id, err := n.Int64()
+	if err != nil {
+		return "", err
+	}
+	c := cursor{OrderBy: lp.OrderBy, Direction: lp.Direction, Id: id, Backward: backward}
+	for _, k := range keys {
+		c.Values = append(c.Values, fields[k.Field])
+	}
+	return c.encode(), nil
+}
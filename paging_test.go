@@ -0,0 +1,90 @@
+package gus
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestUsers_ListCursor(t *testing.T) {
+	o, err := orgsv.Create(CreateOrgParams{Name: "Cursor Co."})
+	assert.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		_, _, err = us.SignUp(SignUpParams{Email: fmt.Sprintf("cursor%d@mail.com", i), Password: "M0nk3yNutz5", OrgId: o.Id})
+		assert.Nil(t, err)
+	}
+	p := ListUsersParams{UserFilters: UserFilters{OrgId: o.Id}, ListArgs: ListArgs{Size: 2, OrderBy: "email", Direction: DirectionAsc, SkipCount: true}}
+	emails := []string{}
+	pages := []*UserListResponse{}
+	for {
+		res, err := us.List(p)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(-1), res.Total)
+		pages = append(pages, res)
+		for _, u := range res.Items {
+			emails = append(emails, u.Email)
+		}
+		if res.NextCursor == "" {
+			break
+		}
+		p.Cursor = res.NextCursor
+	}
+	assert.Equal(t, []string{"cursor0@mail.com", "cursor1@mail.com", "cursor2@mail.com", "cursor3@mail.com", "cursor4@mail.com"}, emails)
+	assert.Equal(t, "", pages[0].PrevCursor)
+
+	// Back from the last page
+	p.Cursor = pages[len(pages)-1].PrevCursor
+	res, err := us.List(p)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(res.Items))
+	assert.Equal(t, "cursor2@mail.com", res.Items[0].Email)
+	assert.Equal(t, "cursor3@mail.com", res.Items[1].Email)
+	assert.NotEqual(t, "", res.NextCursor)
+
+	p.Direction = DirectionDesc
+	_, err = us.List(p)
+	assert.Equal(t, ErrCursorMismatch, err)
+	p.Cursor = "nonsense"
+	_, err = us.List(p)
+	assert.Equal(t, ErrCursorInvalid, err)
+
+	_, err = orgsv.Create(CreateOrgParams{Name: "Cursor Co. 2"})
+	assert.Nil(t, err)
+	orgs, err := orgsv.List(ListOrgsParams{ListArgs: ListArgs{Size: 1, OrderBy: "name", Direction: DirectionAsc}})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(orgs.Items))
+	next, err := orgsv.List(ListOrgsParams{ListArgs: ListArgs{Size: 1, OrderBy: "name", Direction: DirectionAsc, Cursor: orgs.NextCursor}})
+	assert.Nil(t, err)
+	assert.True(t, next.Items[0].Name >= orgs.Items[0].Name)
+	assert.NotEqual(t, orgs.Items[0].Id, next.Items[0].Id)
+}
+
+func TestUsers_ListCursor_NoOrg(t *testing.T) {
+	o, err := orgsv.Create(CreateOrgParams{Name: "Orgless Cursor Co."})
+	assert.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		orgId := int64(0)
+		if i%2 == 0 {
+			orgId = o.Id
+		}
+		_, _, err = us.SignUp(SignUpParams{Email: fmt.Sprintf("orgless-cursor%d@mail.com", i), Password: "M0nk3yNutz5",
+			FirstName: "Orglesscursor", OrgId: orgId})
+		assert.Nil(t, err)
+	}
+	p := ListUsersParams{UserFilters: UserFilters{Name: "Orglesscursor"},
+		ListArgs: ListArgs{Size: 2, OrderBy: "org_name", Direction: DirectionAsc, SkipCount: true}}
+	seen := map[int64]bool{}
+	for page := 0; page < 5; page++ {
+		res, err := us.List(p)
+		assert.Nil(t, err)
+		for _, u := range res.Items {
+			assert.False(t, seen[u.Id])
+			seen[u.Id] = true
+		}
+		if res.NextCursor == "" {
+			break
+		}
+		p.Cursor = res.NextCursor
+	}
+	assert.Equal(t, 5, len(seen))
+}
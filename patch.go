@@ -0,0 +1,93 @@
+package gus
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// patch builds an UPDATE of only the columns which change and records the changes for events and the audit log.
+// It never writes NULL since every column a patch can clear stores an absent value as "" or 0. Names, phone and
+// address columns are written as "" when users and orgs are created, and an org_id or parent_id of 0 means no org
+// or no parent since ids start at 1. Emails and usernames can't be cleared, erasing a user releases them.
+type patch struct {
+	table   string
+	id      int64
+	cols    []string
+	args    []interface{}
+	attrs   Attributes
+	changes map[string]Change
+}
+
+func newPatch(table string, id int64) *patch {
+	return &patch{table: table, id: id, changes: map[string]Change{}}
+}
+
+// str sets the column to *value when value is given and differs from *current, which is updated to match. A
+// pointer to "" clears the column.
+func (pa *patch) str(column string, current *string, value *string) {
+	if value == nil || *value == *current {
+		return
+	}
+	pa.set(column, *current, *value)
+	*current = *value
+}
+
+// int is str for integer columns, 0 clears the column.
+func (pa *patch) int(column string, current *int64, value *int64) {
+	if value == nil || *value == *current {
+		return
+	}
+	pa.set(column, *current, *value)
+	*current = *value
+}
+
+func (pa *patch) set(column string, from interface{}, to interface{}) {
+	pa.cols = append(pa.cols, column+" = ?")
+	pa.args = append(pa.args, to)
+	pa.changes[column] = Change{From: from, To: to}
+}
+
+// derived sets a column which is computed from others, it isn't recorded as a change.
+func (pa *patch) derived(column string, value interface{}) {
+	pa.cols = append(pa.cols, column+" = ?")
+	pa.args = append(pa.args, value)
+}
+
+// attributes records the checked attribute updates which differ from current, current is the owner's own
+// attributes rather than any it inherits.
+func (pa *patch) attributes(current Attributes, updates Attributes) {
+	for name, v := range updates {
+		if reflect.DeepEqual(current[name], v) {
+			continue
+		}
+		if pa.attrs == nil {
+			pa.attrs = Attributes{}
+		}
+		pa.attrs[name] = v
+		pa.changes["attributes."+name] = Change{From: current[name], To: v}
+	}
+}
+
+func (pa *patch) empty() bool {
+	return len(pa.changes) == 0
+}
+
+// exec writes the changed columns, bumping the version. It returns a ConflictError if expectedVersion is given
+// and the row has changed since.
+func (pa *patch) exec(q queryer, expectedVersion *int64) error {
+	sets := append(append([]string{}, pa.cols...), "updated = ?", "version = version + 1")
+	args := append(append([]interface{}{}, pa.args...), Milliseconds(time.Now()), pa.id)
+	query := "UPDATE " + pa.table + " SET " + strings.Join(sets, ", ") + " WHERE id = ? AND deleted = 0"
+	if expectedVersion != nil {
+		query += " AND version = ?"
+		args = append(args, *expectedVersion)
+	}
+	err := CheckUpdated(q.Exec(query, args...))
+	if err == ErrNotFound {
+		if cerr := checkVersion(q, pa.table, pa.id, expectedVersion); cerr != nil {
+			return cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,208 @@
+package gus
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestUpdate_Versions(t *testing.T) {
+	u, _, err := us.SignUp(SignUpParams{Email: "versions@mail.com", FirstName: "Vera", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), u.Version)
+
+	// Two admins edit the same version, the second is refused.
+	v := u.Version
+	first, second := "Vee", "Veronica"
+	assert.Nil(t, us.Update(UpdateUserParams{Id: &u.Id, FirstName: &first, ExpectedVersion: &v}))
+	err = us.Update(UpdateUserParams{Id: &u.Id, FirstName: &second, ExpectedVersion: &v})
+	assert.Equal(t, &ConflictError{Version: 1}, err)
+	got, err := us.Get(u.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "Vee", got.FirstName)
+	assert.Equal(t, int64(1), got.Version)
+
+	// Every write counts, not only Update.
+	assert.Nil(t, us.Suspend(u.Id))
+	got, err = us.Get(u.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), got.Version)
+	err = us.Update(UpdateUserParams{Id: &u.Id, FirstName: &second, ExpectedVersion: &v})
+	assert.Equal(t, &ConflictError{Version: 2}, err)
+	assert.Nil(t, us.Update(UpdateUserParams{Id: &u.Id, FirstName: &second}))
+
+	// The row changing between the read and the write is also a conflict.
+	v = 3
+	assert.Nil(t, checkVersion(us.db, "users", u.Id, &v))
+	v = 2
+	assert.Equal(t, &ConflictError{Version: 3}, checkVersion(us.db, "users", u.Id, &v))
+	assert.Equal(t, ErrNotFound, checkVersion(us.db, "users", 1<<40, &v))
+
+	o, err := orgsv.Create(CreateOrgParams{Name: "Versions"})
+	assert.Nil(t, err)
+	name := "Versions Ltd"
+	ov := o.Version
+	assert.Nil(t, orgsv.Update(UpdateOrgParams{Id: &o.Id, Name: &name, ExpectedVersion: &ov}))
+	err = orgsv.Update(UpdateOrgParams{Id: &o.Id, Name: &name, ExpectedVersion: &ov})
+	assert.Equal(t, &ConflictError{Version: 1}, err)
+	got2, err := orgsv.Get(o.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), got2.Version)
+}
+
+func TestPatch(t *testing.T) {
+	u, _, err := us.SignUp(SignUpParams{Email: "patch@mail.com", FirstName: "Pat", LastName: "Ch", Phone: "021 123", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+	var updated *Event
+	us.After(func(e Event) { updated = &e }, UserUpdated)
+
+	// Only given fields which differ are changed, "" clears a field.
+	first, last, empty := "Pat", "Chen", ""
+	changes, err := us.Patch(UpdateUserParams{Id: &u.Id, FirstName: &first, LastName: &last, Phone: &empty})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]Change{"last_name": {From: "Ch", To: "Chen"}, "phone": {From: "021 123", To: ""}}, changes)
+	us.Wait()
+	if assert.NotNil(t, updated) {
+		assert.Equal(t, changes, updated.Changes)
+	}
+	got, err := us.Get(u.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "Pat", got.FirstName)
+	assert.Equal(t, "Chen", got.LastName)
+	assert.Equal(t, "", got.Phone)
+	assert.Equal(t, int64(1), got.Version)
+
+	// Nothing to change writes nothing.
+	updated = nil
+	changes, err = us.Patch(UpdateUserParams{Id: &u.Id, LastName: &last})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(changes))
+	us.Wait()
+	assert.Nil(t, updated)
+	got, err = us.Get(u.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), got.Version)
+
+	bad := "not an email"
+	_, err = us.Patch(UpdateUserParams{Id: &u.Id, Email: &bad})
+	assert.Equal(t, ErrEmailInvalid, err)
+	taken := "patch-taken@mail.com"
+	_, _, err = us.SignUp(SignUpParams{Email: taken, Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+	_, err = us.Patch(UpdateUserParams{Id: &u.Id, Email: &taken})
+	assert.Equal(t, ErrEmailTaken, err)
+	_, err = us.Patch(UpdateUserParams{})
+	assert.Equal(t, ErrNotFound, err)
+
+	o, err := orgsv.Create(CreateOrgParams{Name: "Patch Co.", Town: "Nelson"})
+	assert.Nil(t, err)
+	name := "Patch Ltd"
+	ochanges, err := orgsv.Patch(UpdateOrgParams{Id: &o.Id, Name: &name, Town: &empty})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]Change{"name": {From: "Patch Co.", To: "Patch Ltd"}, "town": {From: "Nelson", To: ""}}, ochanges)
+	_, err = orgsv.Patch(UpdateOrgParams{Id: &o.Id, Name: &empty})
+	assert.Equal(t, ErrNameRequired, err)
+}
+
+func TestUpdate_UsernameAndOrg(t *testing.T) {
+	f := false
+	uf := NewUsers(us.db, UserOpts{UsernameIsEmail: &f})
+	from, err := orgsv.Create(CreateOrgParams{Name: "Move From"})
+	assert.Nil(t, err)
+	u, _, err := uf.SignUp(SignUpParams{Username: "mover", Email: "mover@mail.com", Password: "M0nk3yNutz5", OrgId: from.Id, Role: RoleAdmin})
+	assert.Nil(t, err)
+	_, _, err = uf.SignUp(SignUpParams{Username: "shaker", Email: "shaker@mail.com", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+
+	// Usernames are independent of emails and unique regardless of case.
+	name, email := "Mover2", "mover2@mail.com"
+	changes, err := uf.Patch(UpdateUserParams{Id: &u.Id, Username: &name, Email: &email})
+	assert.Nil(t, err)
+	assert.Equal(t, Change{From: "mover", To: "Mover2"}, changes["username"])
+	got, err := uf.Get(u.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "Mover2", got.Username)
+	assert.Equal(t, "mover2@mail.com", got.Email)
+	taken, takenEmail, empty := "SHAKER", "Shaker@Mail.com", ""
+	assert.Equal(t, ErrUsernameTaken, uf.Update(UpdateUserParams{Id: &u.Id, Username: &taken}))
+	assert.Equal(t, ErrEmailTaken, uf.Update(UpdateUserParams{Id: &u.Id, Email: &takenEmail}))
+	assert.Equal(t, ErrUsernameRequired, uf.Update(UpdateUserParams{Id: &u.Id, Username: &empty}))
+
+	// When the username is the email it follows the email.
+	v, _, err := us.SignUp(SignUpParams{Email: "follower@mail.com", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+	assert.Equal(t, ErrUsernameIsEmail, us.Update(UpdateUserParams{Id: &v.Id, Username: &name}))
+	email = "follower2@mail.com"
+	assert.Nil(t, us.Update(UpdateUserParams{Id: &v.Id, Username: &email, Email: &email}))
+	got, err = us.Get(v.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "follower2@mail.com", got.Username)
+
+	// Moving replaces the default org's membership and keeps a role the new org can use.
+	o, err := orgsv.Create(CreateOrgParams{Name: "Move To"})
+	assert.Nil(t, err)
+	changes, err = uf.Patch(UpdateUserParams{Id: &u.Id, OrgId: &o.Id})
+	assert.Nil(t, err)
+	assert.Equal(t, Change{From: from.Id, To: o.Id}, changes["org_id"])
+	got, err = uf.Get(u.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, o.Id, got.OrgId)
+	assert.Equal(t, RoleAdmin, got.Role)
+	ms, err := uf.Memberships(u.Id)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(ms)) {
+		assert.Equal(t, o.Id, ms[0].OrgId)
+		assert.Equal(t, RoleAdmin, ms[0].Role)
+	}
+	missing := int64(1 << 40)
+	assert.Equal(t, ErrOrgInvalid, uf.Update(UpdateUserParams{Id: &u.Id, OrgId: &missing}))
+
+	// A member moving to their own org can't do it without permission.
+	claims := &Claims{UserId: u.Id, OrgId: o.Id, Role: RoleMember}
+	assert.Equal(t, ErrForbidden, uf.UpdateAs(claims, UpdateUserParams{Id: &u.Id, OrgId: &from.Id}))
+}
+
+func TestPatch_Clear(t *testing.T) {
+	parent, err := orgsv.Create(CreateOrgParams{Name: "Clear Parent Co."})
+	assert.Nil(t, err)
+	o, err := orgsv.Create(CreateOrgParams{Name: "Clear Child Co.", ParentId: parent.Id, Street: "1 Main St"})
+	assert.Nil(t, err)
+	root, empty := int64(0), ""
+	changes, err := orgsv.Patch(UpdateOrgParams{Id: &o.Id, ParentId: &root, Street: &empty})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]Change{"parent_id": {From: parent.Id, To: int64(0)}, "street": {From: "1 Main St", To: ""}}, changes)
+	got, err := orgsv.Get(o.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), got.ParentId)
+	assert.Equal(t, "", got.Street)
+
+	// A user's org is cleared by removing them from it.
+	u, _, err := us.SignUp(SignUpParams{Email: "patch-clear@mail.com", Password: "M0nk3yNutz5", OrgId: o.Id})
+	assert.Nil(t, err)
+	_, err = us.Patch(UpdateUserParams{Id: &u.Id, OrgId: &root})
+	assert.Equal(t, ErrOrgInvalid, err)
+	assert.Nil(t, orgsv.RemoveMember(o.Id, u.Id))
+	gotUser, err := us.Get(u.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), gotUser.OrgId)
+}
+
+func TestPatch_TakenErr(t *testing.T) {
+	assert.Equal(t, ErrUsernameTaken, takenErr(errors.New("UNIQUE constraint failed: users.username_norm")))
+	assert.Equal(t, ErrEmailTaken, takenErr(errors.New("UNIQUE constraint failed: users.email_norm")))
+	assert.Equal(t, ErrUsernameTaken, takenErr(errors.New("Error 1062: Duplicate entry 'bob' for key 'UC_UsernameNorm'")))
+	assert.Equal(t, ErrEmailTaken, takenErr(errors.New("Error 1062: Duplicate entry 'bob@mail.com' for key 'UC_EmailNorm'")))
+	other := errors.New("connection refused")
+	assert.Equal(t, other, takenErr(other))
+	assert.Nil(t, takenErr(nil))
+
+	f := false
+	uf := NewUsers(us.db, UserOpts{UsernameIsEmail: &f})
+	_, _, err := uf.SignUp(SignUpParams{Username: "taken-patcher", Email: "taken-patcher@mail.com", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+	u, _, err := uf.SignUp(SignUpParams{Username: "patcher", Email: "patcher@mail.com", Password: "M0nk3yNutz5"})
+	assert.Nil(t, err)
+	username := "Taken-Patcher"
+	_, err = uf.Patch(UpdateUserParams{Id: &u.Id, Username: &username})
+	assert.Equal(t, ErrUsernameTaken, err)
+}
@@ -0,0 +1,352 @@
+package gus
+
+import (
+	"database/sql"
+	"github.com/asaskevich/govalidator"
+	"time"
+)
+
+var (
+	ErrRoleNotFound  = ErrInvalid("That role doesn't exist.")
+	ErrRoleBuiltIn   = ErrInvalid("Built-in roles can't be changed.")
+	ErrRoleNameTaken = ErrInvalid("That role name is taken.")
+)
+
+type Permission string
+
+const (
+	PermUsersRead  Permission = "users:read"
+	PermUsersWrite Permission = "users:write"
+	PermOrgRead    Permission = "org:read"
+	PermOrgWrite   Permission = "org:write"
+	PermOrgDelete  Permission = "org:delete"
+	PermRolesWrite Permission = "roles:write"
+)
+
+// Built-in roles, 0 is reserved for no permissions.
+const (
+	RoleNone   Role = 0
+	RoleMember Role = 1
+	RoleAdmin  Role = 2
+	RoleOwner  Role = 3
+)
+
+var builtInRoles = []RoleDef{
+	{Id: RoleMember, Name: "member", Level: 100, Permissions: []Permission{PermUsersRead, PermOrgRead}},
+	{Id: RoleAdmin, Name: "admin", Level: 200, Permissions: []Permission{PermUsersRead, PermUsersWrite, PermOrgRead, PermOrgWrite}},
+	{Id: RoleOwner, Name: "owner", Level: 300, Permissions: []Permission{PermUsersRead, PermUsersWrite, PermOrgRead, PermOrgWrite, PermOrgDelete, PermRolesWrite}},
+}
+
+// RoleDef gives a Role a name and a set of permissions. Roles with an OrgId can only be assigned within that org,
+// roles without are available to every org. A user can only grant roles with a Level no higher than their own.
+type RoleDef struct {
+	Id          Role         `json:"id"`
+	Name        string       `json:"name"`
+	Level       int64        `json:"level"`
+	OrgId       int64        `json:"org_id"`
+	BuiltIn     bool         `json:"built_in"`
+	Permissions []Permission `json:"permissions"`
+	Created     int64        `json:"created"`
+	Updated     int64        `json:"updated"`
+}
+
+func (r *RoleDef) Can(perm Permission) bool {
+	for _, p := range r.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+func NewRoles(db *sql.DB) *Roles {
+	return &Roles{db: db, Hooks: NewHooks()}
+}
+
+type RoleOpts struct {
+	Auditor Auditor // Optional, records changes to roles.
+	Outbox  *Outbox // Optional, events are added in the same transaction as the change for webhook delivery.
+}
+
+type Roles struct {
+	db *sql.DB
+	RoleOpts
+	*Hooks
+	audit AuditContext
+}
+
+// seedRoles inserts the built-in roles, the ids are fixed so that they are the same in every database.
+func seedRoles(db *sql.DB) error {
+	return Tx(db, func(tx *sql.Tx) error {
+		now := Milliseconds(time.Now())
+		for _, r := range builtInRoles {
+			_, err := tx.Exec("INSERT INTO roles (id, name, level, org_id, built_in, created, updated, deleted) values (?, ?, ?, ?, ?, ?, ?, ?)",
+				r.Id, r.Name, r.Level, 0, 1, now, now, 0)
+			if err != nil {
+				return err
+			}
+			err = setPermissions(tx, r.Id, r.Permissions)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type CreateRoleParams struct {
+	Name            string       `json:"name"`
+	Level           int64        `json:"level"`
+	OrgId           int64        `json:"org_id"`
+	Permissions     []Permission `json:"permissions"`
+	CustomValidator `json:"-"`
+}
+
+func (va *CreateRoleParams) Validate() error {
+	if va.CustomValidator != nil {
+		return va.CustomValidator()
+	}
+	if govalidator.IsNull(va.Name) {
+		return ErrNameRequired
+	}
+	if va.Level < 0 {
+		return ErrInvalid("'level' must be 0 or more.")
+	}
+	return nil
+}
+
+func (ro *Roles) Create(p CreateRoleParams) (*RoleDef, error) {
+	r := &RoleDef{Name: p.Name, Level: p.Level, OrgId: p.OrgId, Permissions: p.Permissions, Created: Milliseconds(time.Now()), Updated: Milliseconds(time.Now())}
+	if r.Permissions == nil {
+		r.Permissions = []Permission{}
+	}
+	e := &Event{Kind: RoleCreated, OrgId: p.OrgId, Changes: map[string]Change{"name": {To: r.Name}, "level": {To: r.Level},
+		"permissions": {To: r.Permissions}}}
+	err := ro.emit(e, AuditRoleCreate, func(tx *sql.Tx) error {
+		var count int64
+		err := tx.QueryRow("SELECT count(id) FROM roles WHERE name = ? AND (org_id = 0 OR org_id = ?) AND deleted = 0", p.Name, p.OrgId).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrRoleNameTaken
+		}
+		res, err := tx.Exec("INSERT INTO roles (name, level, org_id, built_in, created, updated, deleted) values (?, ?, ?, ?, ?, ?, ?)",
+			r.Name, r.Level, r.OrgId, 0, r.Created, r.Updated, 0)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		r.Id = Role(id)
+		e.Changes["role_id"] = Change{To: r.Id}
+		return setPermissions(tx, r.Id, r.Permissions)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (ro *Roles) Get(id Role) (*RoleDef, error) {
+	return getRole(ro.db, id)
+}
+
+// List returns the roles available to an org, which includes the global roles.
+func (ro *Roles) List(orgId int64) ([]*RoleDef, error) {
+	rows, err := ro.db.Query("SELECT "+roleCols+" FROM roles WHERE (org_id = 0 OR org_id = ?) AND deleted = 0 ORDER BY id", orgId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*RoleDef{}
+	for rows.Next() {
+		r, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, r := range items {
+		if r.Permissions, err = rolePermissions(ro.db, r.Id); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// SetPermissions replaces the permissions of a custom role.
+func (ro *Roles) SetPermissions(id Role, perms []Permission) error {
+	r, err := ro.Get(id)
+	if err != nil {
+		return err
+	}
+	if r.BuiltIn {
+		return ErrRoleBuiltIn
+	}
+	e := &Event{Kind: RolePermissionsSet, OrgId: r.OrgId, Changes: map[string]Change{"role_id": {To: id},
+		"permissions": {From: r.Permissions, To: perms}}}
+	return ro.emit(e, AuditRolePerms, func(tx *sql.Tx) error {
+		err := CheckUpdated(tx.Exec("UPDATE roles SET updated = ? WHERE id = ? AND deleted = 0", Milliseconds(time.Now()), id))
+		if err != nil {
+			return err
+		}
+		return setPermissions(tx, id, perms)
+	})
+}
+
+// Delete removes a custom role, users which have the role keep the id but it grants no permissions.
+func (ro *Roles) Delete(id Role) error {
+	r, err := ro.Get(id)
+	if err != nil {
+		return err
+	}
+	if r.BuiltIn {
+		return ErrRoleBuiltIn
+	}
+	e := &Event{Kind: RoleDeleted, OrgId: r.OrgId, Changes: map[string]Change{"role_id": {From: id}}}
+	return ro.emit(e, AuditRoleDelete, func(tx *sql.Tx) error {
+		return CheckUpdated(tx.Exec("UPDATE roles SET deleted = 1, updated = ? WHERE id = ? AND deleted = 0", Milliseconds(time.Now()), id))
+	})
+}
+
+// HasPermission returns true if the user's role in their default org grants the permission.
+func (us *Users) HasPermission(userId int64, perm Permission) (bool, error) {
+	var count int64
+	err := us.db.QueryRow("SELECT count(p.role_id) FROM users u JOIN roles r ON r.id = u.role AND r.deleted = 0 "+
+		"JOIN role_permissions p ON p.role_id = r.id WHERE u.id = ? AND u.deleted = 0 AND p.permission = ?", userId, perm).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// HasOrgPermission returns true if the user's role in the org grants the permission.
+func (us *Users) HasOrgPermission(userId int64, orgId int64, perm Permission) (bool, error) {
+	var count int64
+	err := us.db.QueryRow("SELECT count(p.role_id) FROM memberships m JOIN roles r ON r.id = m.role AND r.deleted = 0 "+
+		"JOIN role_permissions p ON p.role_id = r.id WHERE m.user_id = ? AND m.org_id = ? AND m.suspended = 0 AND p.permission = ?",
+		userId, orgId, perm).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// AssignMemberRole changes the user's role in one of their orgs.
+func (us *Orgs) AssignMemberRole(orgId int64, userId int64, role Role) error {
+	e := &Event{Kind: RoleAssigned, OrgId: orgId, UserId: userId, Changes: map[string]Change{"role": {To: role}, "org_id": {To: orgId}}}
+	return us.emit(e, AuditAssignRole, func(tx *sql.Tx) error {
+		return assignMemberRole(tx, orgId, userId, role)
+	})
+}
+
+func assignMemberRole(tx *sql.Tx, orgId int64, userId int64, role Role) error {
+	err := checkRole(tx, role, orgId)
+	if err != nil {
+		return err
+	}
+	var count int64
+	err = tx.QueryRow("SELECT count(user_id) FROM memberships WHERE user_id = ? AND org_id = ?", userId, orgId).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotMember
+	}
+	_, err = tx.Exec("UPDATE memberships SET role = ? WHERE user_id = ? AND org_id = ?", role, userId, orgId)
+	if err != nil {
+		return err
+	}
+	// The user's role is their role in their default org.
+	_, err = tx.Exec("UPDATE users SET role = ?, updated = ?, version = version + 1 WHERE id = ? AND org_id = ?", role, Milliseconds(time.Now()), userId, orgId)
+	return err
+}
+
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// checkRole returns ErrRoleNotFound unless the role can be assigned within the org, RoleNone is always valid.
+func checkRole(q queryer, role Role, orgId int64) error {
+	if role == RoleNone {
+		return nil
+	}
+	var count int64
+	err := q.QueryRow("SELECT count(id) FROM roles WHERE id = ? AND (org_id = 0 OR org_id = ?) AND deleted = 0", role, orgId).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+const roleCols = "id, name, level, org_id, built_in, created, updated"
+
+func getRole(q queryer, id Role) (*RoleDef, error) {
+	r, err := scanRole(q.QueryRow("SELECT "+roleCols+" FROM roles WHERE id = ? AND deleted = 0", id))
+	if err != nil {
+		return nil, err
+	}
+	r.Permissions, err = rolePermissions(q, id)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func scanRole(row scanner) (*RoleDef, error) {
+	var r RoleDef
+	var builtIn int
+	err := CheckNotFound(row.Scan(&r.Id, &r.Name, &r.Level, &r.OrgId, &builtIn, &r.Created, &r.Updated))
+	if err != nil {
+		return nil, err
+	}
+	r.BuiltIn = builtIn > 0
+	return &r, nil
+}
+
+func rolePermissions(q queryer, id Role) ([]Permission, error) {
+	rows, err := q.Query("SELECT permission FROM role_permissions WHERE role_id = ? ORDER BY permission", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	perms := []Permission{}
+	for rows.Next() {
+		var p Permission
+		if err = rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		perms = append(perms, p)
+	}
+	return perms, rows.Err()
+}
+
+func setPermissions(tx *sql.Tx, id Role, perms []Permission) error {
+	_, err := tx.Exec("DELETE FROM role_permissions WHERE role_id = ?", id)
+	if err != nil {
+		return err
+	}
+	seen := map[Permission]bool{}
+	for _, p := range perms {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		_, err = tx.Exec("INSERT INTO role_permissions (role_id, permission) values (?, ?)", id, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package gus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRoles_Permissions(t *testing.T) {
+	roles := NewRoles(us.db)
+	o, err := orgsv.Create(CreateOrgParams{Name: "Roles Inc."})
+	assert.Nil(t, err)
+	other, err := orgsv.Create(CreateOrgParams{Name: "Other Roles Inc."})
+	assert.Nil(t, err)
+	u, _, err := us.SignUp(SignUpParams{Email: "roles@mail.com", Password: "M0nk3yNutz5", OrgId: o.Id, Role: RoleMember})
+	assert.Nil(t, err)
+
+	ok, err := us.HasPermission(u.Id, PermUsersRead)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = us.HasPermission(u.Id, PermUsersWrite)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// Org scoped custom role
+	r, err := roles.Create(CreateRoleParams{Name: "billing", OrgId: o.Id, Permissions: []Permission{PermOrgRead, "billing:write"}})
+	assert.Nil(t, err)
+	_, err = roles.Create(CreateRoleParams{Name: "billing", OrgId: o.Id})
+	assert.Equal(t, ErrRoleNameTaken, err)
+	list, err := roles.List(o.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(list))
+	list, err = roles.List(other.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(list))
+
+	assert.Nil(t, orgsv.AssignMemberRole(o.Id, u.Id, r.Id))
+	ok, err = us.HasPermission(u.Id, "billing:write")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, orgsv.AddMember(other.Id, u.Id, RoleMember))
+	assert.Equal(t, ErrRoleNotFound, orgsv.AssignMemberRole(other.Id, u.Id, r.Id))
+	ok, err = us.HasOrgPermission(u.Id, other.Id, "billing:write")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	assert.Nil(t, roles.SetPermissions(r.Id, []Permission{PermOrgRead}))
+	ok, err = us.HasOrgPermission(u.Id, o.Id, "billing:write")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, ErrRoleBuiltIn, roles.SetPermissions(RoleOwner, nil))
+	assert.Equal(t, ErrRoleBuiltIn, roles.Delete(RoleOwner))
+	assert.Nil(t, roles.Delete(r.Id))
+	ok, err = us.HasPermission(u.Id, PermOrgRead)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
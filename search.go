@@ -0,0 +1,203 @@
+package gus
+
+import (
+	"database/sql"
+	"strings"
+	"unicode"
+)
+
+var ErrSearchQueryRequired = ErrInvalid("A search 'query' is required.")
+
+const (
+	// maxSearchTokens bounds the size of the generated query.
+	maxSearchTokens = 8
+	// minFullTextToken is MySQL's default innodb_ft_min_token_size, shorter tokens are matched with LIKE.
+	minFullTextToken = 3
+)
+
+// SearchSqlLite creates the FTS5 index searched by Users.Search, Seed runs it when the sqlite build supports
+// FTS5 and Search falls back to LIKE matching when it doesn't. MySQL's FULLTEXT index is part of SeedMySql.
+const SearchSqlLite = `
+DROP TABLE IF EXISTS users_fts;
+CREATE VIRTUAL TABLE users_fts USING fts5(first_name, last_name, email, username, phone);
+`
+
+// MigrateSearch adds the search index to a database created before Users.Search and indexes the existing users,
+// it's safe to run more than once.
+func MigrateSearch(db *sql.DB) error {
+	if driverName == "mysql" {
+		var n int
+		err := db.QueryRow("SELECT count(*) FROM information_schema.statistics WHERE table_schema = DATABASE() " +
+			"AND table_name = 'users' AND index_name = 'users_search'").Scan(&n)
+		if err != nil || n > 0 {
+			return err
+		}
+		_, err = db.Exec("CREATE FULLTEXT INDEX users_search ON users (first_name, last_name, email, username, phone)")
+		return err
+	}
+	if err := seedSearch(db); err != nil {
+		return err
+	}
+	return Tx(db, func(tx *sql.Tx) error {
+		if !ftsIndexed(tx) {
+			return nil
+		}
+		_, err := tx.Exec("INSERT INTO users_fts (rowid, first_name, last_name, email, username, phone) " +
+			"SELECT id, first_name, last_name, email, username, phone FROM users WHERE deleted = 0")
+		return err
+	})
+}
+
+// seedSearch creates the sqlite FTS5 index if the driver was built with it.
+func seedSearch(db *sql.DB) error {
+	if driverName != "sqlite3" {
+		return nil
+	}
+	_, err := db.Exec(SearchSqlLite)
+	if err != nil && strings.Contains(err.Error(), "no such module") {
+		return nil
+	}
+	return err
+}
+
+func ftsIndexed(q queryer) bool {
+	if driverName != "sqlite3" {
+		return false
+	}
+	var n int
+	err := q.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'users_fts'").Scan(&n)
+	return err == nil && n > 0
+}
+
+// indexUser refreshes the user's entry in the sqlite FTS5 index, deleted users are removed from it. MySQL's
+// index and the LIKE fallback need no upkeep.
+func indexUser(q queryer, id int64) error {
+	if !ftsIndexed(q) {
+		return nil
+	}
+	if _, err := q.Exec("DELETE FROM users_fts WHERE rowid = ?", id); err != nil {
+		return err
+	}
+	_, err := q.Exec("INSERT INTO users_fts (rowid, first_name, last_name, email, username, phone) "+
+		"SELECT id, first_name, last_name, email, username, phone FROM users WHERE id = ? AND deleted = 0", id)
+	return err
+}
+
+// searchTokens splits a query into lower case words the way the full text indexes do.
+func searchTokens(query string) []string {
+	tokens := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(tokens) > maxSearchTokens {
+		tokens = tokens[:maxSearchTokens]
+	}
+	return tokens
+}
+
+// Search finds users matching every word of query in their name, email, username or phone, words match as
+// prefixes so "jane smi" finds Jane Smith. Results are ordered by relevance so p.OrderBy and p.Cursor are
+// ignored, pages are selected with p.Page and p.Size.
+func (us *Users) Search(query string, p ListArgs) (*UserListResponse, error) {
+	tokens := searchTokens(query)
+	if len(tokens) == 0 {
+		return nil, ErrSearchQueryRequired
+	}
+	p.ApplyDefaults()
+	from := " FROM users u"
+	where := " WHERE u.deleted = 0"
+	var rank string
+	var rankArgs, args []interface{}
+	switch {
+	case ftsIndexed(us.db):
+		match := make([]string, len(tokens))
+		for i, t := range tokens {
+			match[i] = `"` + t + `"*`
+		}
+		from += " JOIN users_fts f ON f.rowid = u.id"
+		where += " AND users_fts MATCH ?"
+		args = append(args, strings.Join(match, " "))
+		rank = "f.rank"
+	case driverName == "mysql":
+		var match []string
+		var short []string
+		for _, t := range tokens {
+			if len(t) < minFullTextToken {
+				short = append(short, t)
+			} else {
+				match = append(match, "+"+t+"*")
+			}
+		}
+		if len(match) > 0 {
+			cond := "MATCH (u.first_name, u.last_name, u.email, u.username, u.phone) AGAINST (? IN BOOLEAN MODE)"
+			where += " AND " + cond
+			args = append(args, strings.Join(match, " "))
+			rank = "-" + cond
+			rankArgs = append(rankArgs, strings.Join(match, " "))
+		}
+		w, a := likeTokens(short)
+		where += w
+		args = append(args, a...)
+	default:
+		w, a := likeTokens(tokens)
+		where += w
+		args = append(args, a...)
+	}
+	if rank == "" {
+		rank, rankArgs = likeRank(tokens)
+	}
+
+	var total int64
+	err := us.db.QueryRow("SELECT count(u.id)"+from+where, args...).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+	q := "SELECT " + listedUserCols + from + " LEFT JOIN orgs o ON u.org_id = o.id" + where +
+		" ORDER BY " + rank + ", u.id LIMIT ? OFFSET ?"
+	args = append(args, rankArgs...)
+	args = append(args, p.Size, p.Page*p.Size)
+	rows, err := us.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []*User{}
+	for rows.Next() {
+		u, err := scanListedUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if err = us.withAttributes(users...); err != nil {
+		return nil, err
+	}
+	return &UserListResponse{Total: total, Items: users, ListArgs: ListArgs{Size: p.Size, Page: p.Page}}, nil
+}
+
+// likeTokens is the portable match, each token must prefix a word of the name or appear in the email, username
+// or phone.
+func likeTokens(tokens []string) (string, []interface{}) {
+	var where string
+	var args []interface{}
+	for _, t := range tokens {
+		// Tokens are letters and digits so need no escaping.
+		where += " AND (LOWER(u.first_name) LIKE ? OR LOWER(u.first_name) LIKE ? OR LOWER(u.last_name) LIKE ?" +
+			" OR LOWER(u.last_name) LIKE ? OR LOWER(u.email) LIKE ? OR LOWER(u.username) LIKE ? OR u.phone LIKE ?)"
+		args = append(args, t+"%", "% "+t+"%", t+"%", "% "+t+"%", "%"+t+"%", "%"+t+"%", "%"+t+"%")
+	}
+	return where, args
+}
+
+// likeRank orders users whose names or username equal a token first, then by name.
+func likeRank(tokens []string) (string, []interface{}) {
+	var exact []string
+	var args []interface{}
+	for _, t := range tokens {
+		exact = append(exact, "CASE WHEN LOWER(u.first_name) = ? OR LOWER(u.last_name) = ? OR LOWER(u.username) = ? THEN 1 ELSE 0 END")
+		args = append(args, t, t, t)
+	}
+	return "(" + strings.Join(exact, " + ") + ") DESC, u.last_name, u.first_name", args
+}
@@ -0,0 +1,59 @@
+package gus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestUsers_Search(t *testing.T) {
+	for _, n := range [][3]string{{"Jane", "Smithson", "+64 21 555 0101"}, {"Janet", "Smith", ""}, {"Smith", "Jones", ""}} {
+		_, _, err := us.SignUp(SignUpParams{Email: strings.ToLower(n[0]+"."+n[1]) + "@search.com", FirstName: n[0], LastName: n[1], Phone: n[2], Password: "M0nk3yNutz5"})
+		assert.Nil(t, err)
+	}
+	res, err := us.Search("jane smith", ListArgs{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), res.Total)
+	names := []string{}
+	for _, u := range res.Items {
+		names = append(names, u.FirstName)
+	}
+	assert.ElementsMatch(t, []string{"Jane", "Janet"}, names)
+
+	// Exact matches rank first.
+	res, err = us.Search("smith", ListArgs{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), res.Total)
+	assert.NotEqual(t, "Jane", res.Items[0].FirstName)
+
+	res, err = us.Search("smithson@search", ListArgs{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), res.Total)
+	res, err = us.Search("0101", ListArgs{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), res.Total)
+
+	// Updates and deletes are searchable straight away.
+	jane := res.Items[0]
+	first := "Jayne"
+	assert.Nil(t, us.Update(UpdateUserParams{Id: &jane.Id, FirstName: &first}))
+	res, err = us.Search("jayne", ListArgs{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), res.Total)
+	assert.Nil(t, us.Delete(jane.Id))
+	res, err = us.Search("jayne", ListArgs{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), res.Total)
+	assert.Nil(t, us.UnDelete(jane.Id))
+	res, err = us.Search("jayne smithson", ListArgs{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), res.Total)
+
+	res, err = us.Search("smith", ListArgs{Size: 1, Page: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), res.Total)
+	assert.Equal(t, 1, len(res.Items))
+
+	_, err = us.Search(" %_ ", ListArgs{})
+	assert.Equal(t, ErrSearchQueryRequired, err)
+}
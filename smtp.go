@@ -0,0 +1,34 @@
+package gus
+
+import (
+	"net/smtp"
+)
+
+// SmtpNotifier renders notifications with Templates and sends them via an SMTP server.
+type SmtpNotifier struct {
+	Addr      string    // host:port of the SMTP server.
+	Auth      smtp.Auth // Optional e.g. smtp.PlainAuth.
+	From      string
+	Templates Templates // Optional will use DefaultTemplates.
+}
+
+func NewSmtpNotifier(addr string, auth smtp.Auth, from string) *SmtpNotifier {
+	return &SmtpNotifier{Addr: addr, Auth: auth, From: from, Templates: DefaultTemplates()}
+}
+
+func (s *SmtpNotifier) Notify(n Notification) error {
+	t := s.Templates
+	if t == nil {
+		t = DefaultTemplates()
+	}
+	m, err := t.Render(n)
+	if err != nil {
+		return err
+	}
+	m.From = s.From
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(s.Addr, s.Auth, s.From, []string{m.To}, msg)
+}
@@ -0,0 +1,93 @@
+package gus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SortFields maps the field names clients may sort by to sql columns, columns not in the map can't be sorted by.
+type SortFields map[string]string
+
+var (
+	// The columns are expressions of the list queries, see GetPage, the fields name their result columns.
+	UserSortFields = SortFields{
+		"id": "u.id", "username": "COALESCE(u.username, '')", "email": "COALESCE(u.email, '')", "first_name": "u.first_name",
+		"last_name": "u.last_name", "phone": "u.phone", "org_id": "u.org_id", "org_name": "COALESCE(o.name, '')",
+		"created": "u.created", "updated": "u.updated", "role": "u.role", "suspended": "u.suspended",
+	}
+	OrgSortFields = SortFields{
+		"id": "id", "parent_id": "COALESCE(parent_id, 0)", "name": "name", "type": "type", "street": "street", "suburb": "suburb",
+		"town": "town", "postcode": "postcode", "country": "country", "created": "created", "updated": "updated",
+		"suspended": "suspended",
+	}
+	AuditSortFields = SortFields{
+		"id": "id", "action": "action", "actor_id": "actor_id", "target_type": "target_type", "target_id": "target_id",
+		"created": "created",
+	}
+	InviteSortFields = SortFields{
+		"id": "id", "code": "code", "org_id": "org_id", "role": "role", "max_uses": "max_uses", "uses": "uses",
+		"expires": "expires", "created": "created", "updated": "updated",
+	}
+)
+
+func (sf SortFields) names() string {
+	names := make([]string, 0, len(sf))
+	for n := range sf {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+type SortKey struct {
+	Field     string
+	Column    string
+	Direction SortDir
+}
+
+// SortKeys parses OrderBy, a comma separated list of fields each of which may be prefixed with '-' to sort
+// descending or '+' to sort ascending, fields without a prefix are sorted by Direction.
+func (p *ListArgs) SortKeys(fields SortFields) ([]SortKey, error) {
+	p.ApplyDefaults()
+	p.Direction = SortDir(strings.ToUpper(string(p.Direction)))
+	if p.Direction != DirectionAsc && p.Direction != DirectionDesc {
+		return nil, sqlErr
+	}
+	keys := []SortKey{}
+	for _, f := range strings.Split(p.OrderBy, ",") {
+		f = strings.TrimSpace(f)
+		dir := p.Direction
+		if strings.HasPrefix(f, "-") {
+			f, dir = f[1:], DirectionDesc
+		} else if strings.HasPrefix(f, "+") {
+			f, dir = f[1:], DirectionAsc
+		}
+		col, ok := fields[f]
+		if !ok {
+			return nil, ErrInvalid(fmt.Sprintf("Can't sort by '%s', sortable fields are: %s.", f, fields.names()))
+		}
+		keys = append(keys, SortKey{Field: f, Column: col, Direction: dir})
+	}
+	return keys, nil
+}
+
+// orderBy returns the sql for an ORDER BY clause, columns are qualified by table when it's given.
+func orderBy(keys []SortKey, table string, reversed bool) string {
+	s := make([]string, len(keys))
+	for i, k := range keys {
+		dir := k.Direction
+		if reversed {
+			dir = reverse(dir)
+		}
+		s[i] = qualify(table, k.Column) + " " + string(dir)
+	}
+	return strings.Join(s, ", ")
+}
+
+func qualify(table string, column string) string {
+	if table == "" {
+		return column
+	}
+	return table + "." + column
+}
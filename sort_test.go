@@ -0,0 +1,43 @@
+package gus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestUsers_ListSort(t *testing.T) {
+	o, err := orgsv.Create(CreateOrgParams{Name: "Sort Co."})
+	assert.Nil(t, err)
+	for _, n := range [][2]string{{"Ann", "Bee"}, {"Bob", "Bee"}, {"Cat", "Ant"}} {
+		_, _, err = us.SignUp(SignUpParams{Email: strings.ToLower(n[0]) + "-sort@mail.com", FirstName: n[0], LastName: n[1], Password: "M0nk3yNutz5", OrgId: o.Id})
+		assert.Nil(t, err)
+	}
+	p := ListUsersParams{UserFilters: UserFilters{OrgId: o.Id}, ListArgs: ListArgs{OrderBy: "last_name, -first_name", Direction: DirectionAsc}}
+	res, err := us.List(p)
+	assert.Nil(t, err)
+	names := []string{}
+	for _, u := range res.Items {
+		names = append(names, u.FirstName)
+	}
+	assert.Equal(t, []string{"Cat", "Bob", "Ann"}, names)
+
+	// Cursors follow every key
+	p.Size = 2
+	res, err = us.List(p)
+	assert.Nil(t, err)
+	p.Cursor = res.NextCursor
+	res, err = us.List(p)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(res.Items))
+	assert.Equal(t, "Ann", res.Items[0].FirstName)
+
+	_, err = us.List(ListUsersParams{ListArgs: ListArgs{OrderBy: "password_hash"}})
+	assert.IsType(t, &ValidationError{}, err)
+	assert.Contains(t, err.Error(), "Can't sort by 'password_hash'")
+	assert.Contains(t, err.Error(), "first_name")
+	_, err = us.List(ListUsersParams{ListArgs: ListArgs{OrderBy: "id", Direction: "sideways"}})
+	assert.IsType(t, &ValidationError{}, err)
+	_, err = orgsv.List(ListOrgsParams{ListArgs: ListArgs{OrderBy: "-name,id"}})
+	assert.Nil(t, err)
+}
@@ -8,19 +8,49 @@ CREATE TABLE users (
     uid VARCHAR(36) NULL,
     username VARCHAR(128) NULL,
     email VARCHAR(128) NULL,
+    email_norm VARCHAR(128) NULL,
+    username_norm VARCHAR(128) NULL,
     first_name VARCHAR(128) NULL,
     last_name VARCHAR(128) NULL,
     phone VARCHAR(30) NULL,
     invite_code VARCHAR(30) NULL,
     password_hash VARCHAR(256) NULL,
     org_id INT,
-    updated DATE NOT NULL,
-    created DATE NOT NULL,
+    updated BIGINT NOT NULL,
+    created BIGINT NOT NULL,
     suspended BIT,
     deleted BIT,
+    suspended_by_org BIGINT NULL DEFAULT 0,
+    deleted_by_org BIGINT NULL DEFAULT 0,
     role INT,
-    CONSTRAINT UC_Email UNIQUE (email),
-    CONSTRAINT UC_Username UNIQUE (username)
+    passive BIT NULL,
+    activated BIT NULL,
+    version INT NOT NULL DEFAULT 0,
+    erased BIGINT NOT NULL DEFAULT 0,
+    deleted_at BIGINT NOT NULL DEFAULT 0,
+    CONSTRAINT UC_EmailNorm UNIQUE (email_norm),
+    CONSTRAINT UC_UsernameNorm UNIQUE (username_norm)
+);
+CREATE INDEX users_updated ON users (updated, id);
+CREATE INDEX users_created ON users (created, id);
+CREATE INDEX users_org ON users (org_id, updated, id);
+
+DROP TABLE IF EXISTS user_attributes;
+CREATE TABLE user_attributes (
+    user_id INT NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    str_value TEXT NULL,
+    num_value REAL NULL,
+    PRIMARY KEY (user_id, name)
+);
+
+DROP TABLE IF EXISTS org_attributes;
+CREATE TABLE org_attributes (
+    org_id INT NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    str_value TEXT NULL,
+    num_value REAL NULL,
+    PRIMARY KEY (org_id, name)
 );
 
 DROP TABLE IF EXISTS password_resets;
@@ -29,10 +59,137 @@ CREATE TABLE password_resets (
     user_id INT NOT NULL,
     email VARCHAR(128) NULL,
     reset_token VARCHAR(256) NULL,
-    created DATE NOT NULL,
+    created BIGINT NOT NULL,
+    deleted BIT
+);
+
+DROP TABLE IF EXISTS email_changes;
+CREATE TABLE email_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INT NOT NULL,
+    old_email VARCHAR(128) NULL,
+    new_email VARCHAR(128) NOT NULL,
+    token VARCHAR(256) NOT NULL,
+    created INT NOT NULL,
+    deleted BIT
+);
+
+DROP TABLE IF EXISTS invites;
+CREATE TABLE invites (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code VARCHAR(30) NOT NULL,
+    org_id INT,
+    role INT,
+    max_uses INT NOT NULL DEFAULT 0,
+    uses INT NOT NULL DEFAULT 0,
+    expires BIGINT NOT NULL DEFAULT 0,
+    created BIGINT NOT NULL,
+    updated BIGINT NOT NULL,
+    deleted BIT,
+    CONSTRAINT UC_Code UNIQUE (code)
+);
+
+DROP TABLE IF EXISTS invite_redemptions;
+CREATE TABLE invite_redemptions (
+    invite_id INT NOT NULL,
+    user_id INT NOT NULL,
+    created BIGINT NOT NULL
+);
+
+DROP TABLE IF EXISTS roles;
+CREATE TABLE roles (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(128) NOT NULL,
+    level BIGINT NOT NULL DEFAULT 0,
+    org_id INT NOT NULL DEFAULT 0,
+    built_in BIT,
+    created BIGINT NOT NULL,
+    updated BIGINT NOT NULL,
+    deleted BIT
+);
+
+DROP TABLE IF EXISTS role_permissions;
+CREATE TABLE role_permissions (
+    role_id INT NOT NULL,
+    permission VARCHAR(64) NOT NULL,
+    PRIMARY KEY (role_id, permission)
+);
+
+DROP TABLE IF EXISTS memberships;
+CREATE TABLE memberships (
+    user_id INT NOT NULL,
+    org_id INT NOT NULL,
+    role INT,
+    joined BIGINT NOT NULL,
+    suspended BIT,
+    PRIMARY KEY (user_id, org_id)
+);
+CREATE INDEX memberships_org ON memberships (org_id, user_id);
+
+DROP TABLE IF EXISTS org_invitations;
+CREATE TABLE org_invitations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    org_id INT NOT NULL,
+    email VARCHAR(128) NOT NULL,
+    email_norm VARCHAR(128) NULL,
+    role INT,
+    status VARCHAR(16) NOT NULL,
+    created BIGINT NOT NULL,
+    updated BIGINT NOT NULL
+);
+
+DROP TABLE IF EXISTS audit_events;
+CREATE TABLE audit_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    action VARCHAR(64) NOT NULL,
+    actor_id INT NOT NULL,
+    target_type VARCHAR(16) NOT NULL,
+    target_id INT NOT NULL,
+    ip VARCHAR(64) NULL,
+    changes TEXT NULL,
+    created BIGINT NOT NULL
+);
+CREATE INDEX audit_events_target ON audit_events (target_type, target_id);
+CREATE INDEX audit_events_actor ON audit_events (actor_id);
+
+DROP TABLE IF EXISTS outbox;
+CREATE TABLE outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind VARCHAR(64) NOT NULL,
+    user_id BIGINT NOT NULL DEFAULT 0,
+    payload TEXT NOT NULL,
+    created BIGINT NOT NULL,
+    fanned_out BIT
+);
+CREATE INDEX outbox_fanned_out ON outbox (fanned_out, id);
+CREATE INDEX outbox_user ON outbox (user_id);
+
+DROP TABLE IF EXISTS webhooks;
+CREATE TABLE webhooks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url VARCHAR(2048) NOT NULL,
+    secret VARCHAR(256) NOT NULL,
+    kinds TEXT NOT NULL,
+    created BIGINT NOT NULL,
+    updated BIGINT NOT NULL,
     deleted BIT
 );
 
+DROP TABLE IF EXISTS webhook_deliveries;
+CREATE TABLE webhook_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    outbox_id INT NOT NULL,
+    webhook_id INT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    attempts INT NOT NULL,
+    next_attempt BIGINT NOT NULL,
+    last_error TEXT NULL,
+    created BIGINT NOT NULL,
+    updated BIGINT NOT NULL
+);
+CREATE INDEX webhook_deliveries_due ON webhook_deliveries (status, next_attempt);
+CREATE INDEX webhook_deliveries_outbox ON webhook_deliveries (outbox_id);
+
 DROP TABLE IF EXISTS password_attempts;
 CREATE TABLE password_attempts (
     username VARCHAR(250),
@@ -42,12 +199,34 @@ CREATE TABLE password_attempts (
 DROP TABLE IF EXISTS orgs;
 CREATE TABLE orgs (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
+    parent_id INT NULL DEFAULT 0,
     name VARCHAR(128) NOT NULL,
+    street VARCHAR(512) NULL,
+    suburb VARCHAR(512) NULL,
+    town VARCHAR(512) NULL,
+    postcode VARCHAR(512) NULL,
+    country VARCHAR(512) NULL,
     type INT,
-    created DATE NOT NULL,
-    updated DATE NOT NULL,
+    created BIGINT NOT NULL,
+    updated BIGINT NOT NULL,
     suspended BIT,
-    deleted BIT
+    deleted BIT,
+    version INT NOT NULL DEFAULT 0
 );
+CREATE INDEX orgs_updated ON orgs (updated, id);
+CREATE INDEX orgs_created ON orgs (created, id);
+CREATE INDEX orgs_name ON orgs (name, id);
+CREATE INDEX orgs_parent ON orgs (parent_id);
+
+`
 
+const MigrateMembershipsSqlLite = `
+CREATE TABLE IF NOT EXISTS memberships (
+    user_id INT NOT NULL,
+    org_id INT NOT NULL,
+    role INT,
+    joined BIGINT NOT NULL,
+    suspended BIT,
+    PRIMARY KEY (user_id, org_id)
+);
 `
@@ -16,33 +16,28 @@ type Suspender struct {
 }
 
 func (su *Suspender) Suspend(id int64) error {
-	stmt, err := su.db.Prepare(fmt.Sprintf("UPDATE %s SET suspended = 1, updated = ? WHERE id = ? AND deleted = 0", su.table))
-	if err != nil {
-		return err
-	}
-	return CheckUpdated(stmt.Exec(Milliseconds(time.Now()), id))
+	return su.set(su.db, "suspended", 1, id)
 }
 
 func (su *Suspender) Restore(id int64) error {
-	stmt, err := su.db.Prepare(fmt.Sprintf("UPDATE %s SET suspended = 0, updated = ? WHERE id = ? AND deleted = 0", su.table))
-	if err != nil {
-		return err
-	}
-	return CheckUpdated(stmt.Exec(Milliseconds(time.Now()), id))
+	return su.set(su.db, "suspended", 0, id)
 }
 
 func (su *Suspender) Delete(id int64) error {
-	stmt, err := su.db.Prepare(fmt.Sprintf("UPDATE %s SET deleted = 1, updated = ? WHERE id = ? AND deleted = 0", su.table))
-	if err != nil {
-		return err
-	}
-	return CheckUpdated(stmt.Exec(Milliseconds(time.Now()), id))
+	return su.set(su.db, "deleted", 1, id)
 }
 
 func (su *Suspender) UnDelete(id int64) error {
-	stmt, err := su.db.Prepare(fmt.Sprintf("UPDATE %s SET deleted = 0, updated = ? WHERE id = ? AND deleted = 1", su.table))
-	if err != nil {
-		return err
-	}
-	return CheckUpdated(stmt.Exec(Milliseconds(time.Now()), id))
+	return su.unDelete(su.db, id)
+}
+
+// set updates the suspended or deleted flag of a row which isn't deleted, q may be a transaction.
+func (su *Suspender) set(q queryer, column string, value int, id int64) error {
+	return CheckUpdated(q.Exec(fmt.Sprintf("UPDATE %s SET %s = ?, updated = ?, version = version + 1 WHERE id = ? AND deleted = 0", su.table, column),
+		value, Milliseconds(time.Now()), id))
+}
+
+func (su *Suspender) unDelete(q queryer, id int64) error {
+	return CheckUpdated(q.Exec(fmt.Sprintf("UPDATE %s SET deleted = 0, updated = ?, version = version + 1 WHERE id = ? AND deleted = 1", su.table),
+		Milliseconds(time.Now()), id))
 }
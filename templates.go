@@ -0,0 +1,163 @@
+package gus
+
+import (
+	"bytes"
+	"fmt"
+	htemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	ttemplate "text/template"
+	"time"
+)
+
+// Message is a rendered notification ready to be sent.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	Html    string // Optional, when supplied the message is sent as multipart/alternative.
+}
+
+// MessageTemplate renders one kind of notification, Html is optional.
+type MessageTemplate struct {
+	Subject *ttemplate.Template
+	Text    *ttemplate.Template
+	Html    *htemplate.Template
+}
+
+// Templates maps each kind of notification to its template, use Set to override the defaults.
+type Templates map[NotificationKind]*MessageTemplate
+
+var defaultTemplates = map[NotificationKind][3]string{
+	NotifyActivation: {
+		"Activate your account",
+		"Hi {{.User.FirstName}},\n\nUse this token to activate your account and set your password:\n\n{{.Token}}\n",
+		"<p>Hi {{.User.FirstName}},</p><p>Use this token to activate your account and set your password:</p><p><code>{{.Token}}</code></p>",
+	},
+	NotifyPasswordReset: {
+		"Reset your password",
+		"Hi {{.User.FirstName}},\n\nUse this token to reset your password:\n\n{{.Token}}\n\nIf you didn't ask to reset your password you can ignore this message.\n",
+		"<p>Hi {{.User.FirstName}},</p><p>Use this token to reset your password:</p><p><code>{{.Token}}</code></p><p>If you didn't ask to reset your password you can ignore this message.</p>",
+	},
+	NotifyLockout: {
+		"Your account has been locked",
+		"Hi {{.User.FirstName}},\n\nThere were too many attempts to sign in to your account so it has been temporarily locked.\n",
+		"<p>Hi {{.User.FirstName}},</p><p>There were too many attempts to sign in to your account so it has been temporarily locked.</p>",
+	},
+	NotifyEmailChangeConfirm: {
+		"Confirm your new email",
+		"Hi {{.User.FirstName}},\n\nUse this token to confirm {{.To}} as your new email:\n\n{{.Token}}\n",
+		"<p>Hi {{.User.FirstName}},</p><p>Use this token to confirm {{.To}} as your new email:</p><p><code>{{.Token}}</code></p>",
+	},
+	NotifyEmailChangeNotice: {
+		"Your email is being changed",
+		"Hi {{.User.FirstName}},\n\nA request was made to change the email of your account to {{index .Data \"new_email\"}}. If this wasn't you please contact us.\n",
+		"<p>Hi {{.User.FirstName}},</p><p>A request was made to change the email of your account to {{index .Data \"new_email\"}}. If this wasn't you please contact us.</p>",
+	},
+	NotifyEmailChanged: {
+		"Your email has been changed",
+		"Hi {{.User.FirstName}},\n\nThe email of your account has been changed to {{index .Data \"new_email\"}}. If this wasn't you please contact us.\n",
+		"<p>Hi {{.User.FirstName}},</p><p>The email of your account has been changed to {{index .Data \"new_email\"}}. If this wasn't you please contact us.</p>",
+	},
+}
+
+// DefaultTemplates returns a fresh copy of the built-in templates.
+func DefaultTemplates() Templates {
+	t := Templates{}
+	for k, v := range defaultTemplates {
+		if err := t.Set(k, v[0], v[1], v[2]); err != nil {
+			panic(err)
+		}
+	}
+	return t
+}
+
+// Set parses and stores the templates for a kind of notification, html may be empty.
+func (t Templates) Set(kind NotificationKind, subject, text, html string) error {
+	mt := &MessageTemplate{}
+	var err error
+	mt.Subject, err = ttemplate.New(string(kind) + "_subject").Parse(subject)
+	if err != nil {
+		return err
+	}
+	mt.Text, err = ttemplate.New(string(kind) + "_text").Parse(text)
+	if err != nil {
+		return err
+	}
+	if html != "" {
+		mt.Html, err = htemplate.New(string(kind) + "_html").Parse(html)
+		if err != nil {
+			return err
+		}
+	}
+	t[kind] = mt
+	return nil
+}
+
+// Render executes the templates for the notification's kind.
+func (t Templates) Render(n Notification) (*Message, error) {
+	mt, ok := t[n.Kind]
+	if !ok {
+		return nil, fmt.Errorf("gus: no template for notification '%s'", n.Kind)
+	}
+	if n.User == nil {
+		n.User = &User{}
+	}
+	m := &Message{To: n.To}
+	var b bytes.Buffer
+	if err := mt.Subject.Execute(&b, n); err != nil {
+		return nil, err
+	}
+	m.Subject = b.String()
+	b.Reset()
+	if err := mt.Text.Execute(&b, n); err != nil {
+		return nil, err
+	}
+	m.Text = b.String()
+	if mt.Html != nil {
+		b.Reset()
+		if err := mt.Html.Execute(&b, n); err != nil {
+			return nil, err
+		}
+		m.Html = b.String()
+	}
+	return m, nil
+}
+
+// Bytes returns the message in RFC 5322 format.
+func (m *Message) Bytes() ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.From)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	if m.Html == "" {
+		b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+		b.WriteString(crlf(m.Text))
+		return b.Bytes(), nil
+	}
+	w := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", w.Boundary())
+	parts := [][2]string{{"text/plain", m.Text}, {"text/html", m.Html}}
+	for _, p := range parts {
+		pw, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {p[0] + "; charset=utf-8"}})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = pw.Write([]byte(crlf(p[1]))); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func crlf(s string) string {
+	return strings.Replace(strings.Replace(s, "\r\n", "\n", -1), "\n", "\r\n", -1)
+}
@@ -17,8 +17,11 @@ var (
 	ErrEmailRequired           = ErrInvalid("'email' required.")
 	ErrUsernameRequired        = ErrInvalid("'username' required.")
 	ErrUsernameOrEmailRequired = ErrInvalid("'username' or 'email' required.")
+	ErrUsernameIsEmail         = ErrInvalid("The 'username' is the email, change the 'email' instead.")
+	ErrOrgInvalid              = ErrInvalid("'org_id' must be an existing org.")
 	ErrPasswordRequired        = ErrInvalid("'password' required.")
 	ErrInvalidResetToken       = ErrInvalid("Invalid reset token.")
+	ErrUserPassive             = ErrInvalid("This user is passive, cannot assign a role")
 	ErrPasswordInvalid         = ErrInvalid(
 		"'new_password' must contain: 1 Upper, 1 Lower, 1 Number, 1 Special and 8 Chars",
 		"OR any alphanumeric with a minimum of 15 chars.")
@@ -33,24 +36,36 @@ type UserOpts struct {
 	// (as opposed to registered) this is the length of the generated password length.
 	UsernameIsEmail  *bool // When true (default) the username is the email address. When false the username can be specified independently. In either scenario both can be used to sign in with the password.
 	ResetTokenExpiry int64 // ResetTokenExpiry Seconds before token expired.
+	// When true Update will refuse to change the email, callers must use RequestEmailChange and ConfirmEmailChange.
+	ConfirmEmailChanges bool
+	EmailChangeExpiry   int64    // Seconds before an email change token expires.
+	Notifier            Notifier // Optional, delivers activation, reset, lockout and email change notifications.
+	// When true SignUp requires a valid InviteCode, the user is added to the invite's org with the invite's role.
+	RequireInvite bool
+	Auditor       Auditor         // Optional, records sign ups, sign ins and changes to users.
+	Outbox        *Outbox         // Optional, events are added in the same transaction as the change for webhook delivery.
+	Attributes    AttributeSchema // Optional, the custom attributes users may have.
+	Normalizer    *Normalizer     // Optional, how emails and usernames are canonicalized, DefaultNormalizer if nil.
 }
 
 type User struct {
-	Id        int64  `json:"id"`
-	Uid       string `json:"uid"`      // A universally unique id such as a uuid
-	Username  string `json:"username"` // Same as email?? If not supplied.
-	Email     string `json:"email"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Phone     string `json:"phone"`
-	OrgId     int64  `json:"org_id"`
-	OrgName   string `json:"org_name"`
-	Updated   int64  `json:"updated"`
-	Created   int64  `json:"created"`
-	Role      Role   `json:"role"`
-	Activated bool   `json:"activated"`
-	Passive   bool   `json:"passive"`
-	Suspended bool   `json:"suspended"`
+	Id         int64      `json:"id"`
+	Uid        string     `json:"uid"`      // A universally unique id such as a uuid
+	Username   string     `json:"username"` // Same as email?? If not supplied.
+	Email      string     `json:"email"`
+	FirstName  string     `json:"first_name"`
+	LastName   string     `json:"last_name"`
+	Phone      string     `json:"phone"`
+	OrgId      int64      `json:"org_id"`
+	OrgName    string     `json:"org_name"`
+	Updated    int64      `json:"updated"`
+	Created    int64      `json:"created"`
+	Role       Role       `json:"role"`
+	Activated  bool       `json:"activated"`
+	Passive    bool       `json:"passive"`
+	Suspended  bool       `json:"suspended"`
+	Version    int64      `json:"version"` // Incremented by every change, see UpdateUserParams.ExpectedVersion.
+	Attributes Attributes `json:"attributes,omitempty"`
 }
 
 type UserWithClaims struct {
@@ -58,10 +73,14 @@ type UserWithClaims struct {
 	*Claims
 }
 
+// Claims describe what the user can do in their active org.
 type Claims struct {
-	Role         Role  `json:"role"`
-	OrgId        int64 `json:"org_id"`
-	OrgSuspended bool  `json:"org_suspended"`
+	UserId              int64   `json:"user_id"`
+	Role                Role    `json:"role"`
+	OrgId               int64   `json:"org_id"` // The active org.
+	OrgSuspended        bool    `json:"org_suspended"`
+	MembershipSuspended bool    `json:"membership_suspended"`
+	OrgIds              []int64 `json:"org_ids,omitempty"` // Every org the user belongs to, populated on SignIn.
 }
 
 type UserWithToken struct {
@@ -76,8 +95,11 @@ func NewUsers(db *sql.DB, opt UserOpts) *Users {
 	if opt.ResetTokenExpiry == 0 {
 		opt.ResetTokenExpiry = 24 * 60 * 60 * 1000
 	}
+	if opt.EmailChangeExpiry == 0 {
+		opt.EmailChangeExpiry = 24 * 60 * 60
+	}
 	if opt.PassGen == nil {
-		opt.PassGen = RandStringBytesMaskImprSrc
+		opt.PassGen = SecureRandString
 	}
 	if opt.UsernameIsEmail == nil {
 		t := true
@@ -87,6 +109,7 @@ func NewUsers(db *sql.DB, opt UserOpts) *Users {
 		db:        db,
 		Suspender: NewSuspender("users", db),
 		UserOpts:  opt,
+		Hooks:     NewHooks(),
 	}
 }
 
@@ -94,6 +117,9 @@ type Users struct {
 	db *sql.DB
 	*Suspender
 	UserOpts
+	*Hooks
+	audit AuditContext
+	guard func(q queryer) error // Runs first in the transaction of each operation, see guarded.
 }
 
 func hashPassword(password string) (string, error) {
@@ -105,16 +131,17 @@ func hashPassword(password string) (string, error) {
 }
 
 type SignUpParams struct {
-	Username        string `json:"username"`
-	InviteCode      string `json:"invite_code"`
-	Password        string `json:"password"`
-	Email           string `json:"email"`
-	FirstName       string `json:"first_name"`
-	LastName        string `json:"last_name"`
-	Phone           string `json:"phone"`
-	OrgId           int64  `json:"org_id"`
-	Role            Role   `json:"role"`
-	Passive         bool   `json:"passive"`
+	Username        string     `json:"username"`
+	InviteCode      string     `json:"invite_code"`
+	Password        string     `json:"password"`
+	Email           string     `json:"email"`
+	FirstName       string     `json:"first_name"`
+	LastName        string     `json:"last_name"`
+	Phone           string     `json:"phone"`
+	OrgId           int64      `json:"org_id"`
+	Role            Role       `json:"role"`
+	Passive         bool       `json:"passive"`
+	Attributes      Attributes `json:"attributes"` // Checked against UserOpts.Attributes.
 	CustomValidator `json:"-"`
 }
 
@@ -151,13 +178,13 @@ func (us *Users) Exists(p ExistsParams) (bool, error) {
 }
 
 func (us *Users) exists(tx *sql.Tx, p ExistsParams) (bool, error) {
-	existingQ, err := tx.Prepare("SELECT username, email  FROM users WHERE deleted = 0 AND username = ? OR email = ?")
+	emailKey, usernameKey, err := us.normalizer().keys(p.Email, p.Username)
 	if err != nil {
 		return true, err
 	}
-
-	var username, email string
-	err = existingQ.QueryRow(p.Username, p.Email).Scan(&username, &email)
+	var username, email sql.NullString
+	err = lookupUsers(liveUsers).where("u.username_norm = ? OR u.email_norm = ?", usernameKey, emailKey).
+		row(tx, "u.username_norm, u.email_norm", "users u").Scan(&username, &email)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return false, nil
@@ -165,10 +192,10 @@ func (us *Users) exists(tx *sql.Tx, p ExistsParams) (bool, error) {
 		return true, err
 	}
 
-	if strings.ToLower(email) == strings.ToLower(p.Email) {
+	if email.String == emailKey {
 		return true, ErrEmailTaken
 	}
-	if strings.ToLower(username) == strings.ToLower(p.Username) {
+	if username.String == usernameKey {
 		return true, ErrUsernameTaken
 	}
 	return false, nil
@@ -183,31 +210,56 @@ func (us *Users) SignUp(p SignUpParams) (*User, string, error) {
 	if p.Passive && p.Email == "" {
 		p.Email = uuid.NewV4().String() + "@passive-user.gus"
 	}
-	err := Tx(us.db, func(tx *sql.Tx) error {
+	p.Email, p.Username = us.normalizer().Clean(p.Email), us.normalizer().Clean(p.Username)
+	attrs, err := us.Attributes.check(p.Attributes)
+	if err != nil {
+		return nil, "", err
+	}
+	// Before hooks see the user as given, an invite may still change the org and role.
+	e := &Event{Kind: UserCreated, User: &User{Username: p.Username, Email: p.Email, FirstName: p.FirstName, LastName: p.LastName,
+		Phone: p.Phone, OrgId: p.OrgId, Role: p.Role, Passive: p.Passive}}
+	signUp := func(tx *sql.Tx) error {
 		exists, err := us.exists(tx, ExistsParams{Username: p.Username, Email: p.Email})
 		if exists {
 			return err
 		}
+		var invite *Invite
+		if p.InviteCode != "" {
+			invite, err = checkInvite(tx, p.InviteCode)
+			if err != nil {
+				return err
+			}
+			p.OrgId = invite.OrgId
+			p.Role = invite.Role
+		} else if us.RequireInvite {
+			return ErrInviteRequired
+		}
 		stmt, err := tx.Prepare("INSERT INTO users(" +
 			"username, uid, email, first_name, " +
 			"last_name, phone, password_hash, org_id, " +
 			"updated, created, deleted, role, " +
-			"suspended, invite_code, passive, activated) " +
+			"suspended, invite_code, passive, activated, " +
+			"email_norm, username_norm) " +
 			"values(" +
 			"?,?,?,?," +
 			"?,?,?,?," +
 			"?,?,?,?," +
-			"?, ?, ?, ?)")
+			"?, ?, ?, ?," +
+			"?, ?)")
 		if err != nil {
 			return errors.WithStack(err)
 		}
 		if *us.UserOpts.UsernameIsEmail || p.Username == "" {
 			p.Username = p.Email
 		}
+		emailKey, usernameKey, err := us.normalizer().keys(p.Email, p.Username)
+		if err != nil {
+			return err
+		}
 		u = &User{
 			Uid: uuid.NewV4().String(), Username: p.Username, Email: p.Email, FirstName: p.FirstName,
 			LastName: p.LastName, Phone: p.Phone, OrgId: p.OrgId, Created: Milliseconds(time.Now()),
-			Updated: Milliseconds(time.Now()), Role: p.Role, Suspended: false, Passive: p.Passive, Activated:false}
+			Updated: Milliseconds(time.Now()), Role: p.Role, Suspended: false, Passive: p.Passive, Activated: false}
 
 		if p.Password == "" {
 			p.Password = us.UserOpts.PassGen(128)
@@ -225,7 +277,8 @@ func (us *Users) SignUp(p SignUpParams) (*User, string, error) {
 			u.Username, u.Uid, u.Email, u.FirstName,
 			u.LastName, u.Phone, hash, u.OrgId,
 			u.Updated, u.Created, 0, u.Role,
-			u.Suspended, p.InviteCode, p.Passive, false)
+			u.Suspended, p.InviteCode, p.Passive, false,
+			emailKey, usernameKey)
 		if err != nil {
 			return err
 		}
@@ -234,48 +287,70 @@ func (us *Users) SignUp(p SignUpParams) (*User, string, error) {
 			return err
 		}
 		id = lid
+		if err = indexUser(tx, id); err != nil {
+			return err
+		}
+		if err = userAttrs.save(tx, id, attrs); err != nil {
+			return err
+		}
+		u.Attributes = attrs.set()
+		if u.OrgId > 0 {
+			_, err = tx.Exec("INSERT INTO memberships (user_id, org_id, role, joined, suspended) values (?, ?, ?, ?, ?)", id, u.OrgId, u.Role, u.Created, 0)
+			if err != nil {
+				return err
+			}
+		}
+		if invite != nil {
+			err = redeemInvite(tx, invite, id)
+			if err != nil {
+				return err
+			}
+		}
+		u.Id = id
+		e.UserId, e.User = id, u
 		return nil
-	})
+	}
+	err = us.emit(e, AuditSignUp, signUp)
 	if err != nil {
 		return nil, "", err
 	}
-	u.Id = id
 	if givenPassword {
 		return u, "", nil
 	}
 
 	if !u.Passive {
-		at, err := us.ResetPassword(ResetPasswordParams{Email: p.Email})
+		_, at, err := us.resetPassword(p.Email)
 		if err != nil {
 			return nil, "", err
 		}
 		activateToken = at
+		notify(us.Notifier, Notification{Kind: NotifyActivation, To: u.Email, User: u, Token: at})
 	}
 	return u, activateToken, nil
 }
 
 func (us *Users) Get(id int64) (*User, error) {
-	stmt, err := us.db.Prepare("SELECT id, uid, username, email, first_name, last_name, phone, org_id, created, updated, role, suspended, passive, activated from users WHERE id =  ? AND deleted = 0 LIMIT 1")
+	u, err := scanUser(lookupUsers(liveUsers).byId(id).row(us.db, userCols, "users u"))
 	if err != nil {
 		return nil, err
 	}
-	return scanUser(stmt.QueryRow(id))
+	return u, us.withAttributes(u)
 }
 
-// GetByUsername returns a user by username (or email) as well as a password hash.
+// GetByUsername returns a user by username (or email) as well as a password hash, the name is normalized
+// before matching.
 func (us *Users) GetByUsername(username string) (*UserWithClaims, string, error) {
-	stmt, err := us.db.Prepare("SELECT u.password_hash, u.id, u.uid, u.username, u.email, u.first_name, u.last_name, u.phone, u.org_id, u.created, u.updated, u.role, u.suspended, COALESCE(o.suspended, 0), passive, activated from users u left join orgs o on u.org_id = o.id WHERE u.email = ? OR u.username = ? AND u.deleted = 0 LIMIT 1")
-	if err != nil {
-		return nil, "", err
-	}
-	row := stmt.QueryRow(username, username)
+	row := lookupUsers(liveUsers).byName(us.normalizer(), username).row(us.db, "u.password_hash, u.id, u.uid, u.username, u.email, "+
+		"u.first_name, u.last_name, u.phone, u.org_id, u.created, u.updated, u.role, u.suspended, "+
+		"CASE WHEN o.suspended = 1 OR o.deleted = 1 THEN 1 ELSE 0 END, COALESCE(m.suspended, 0), u.passive, u.activated, u.version",
+		"users u LEFT JOIN orgs o ON u.org_id = o.id LEFT JOIN memberships m ON m.user_id = u.id AND m.org_id = u.org_id")
 	var u User
 	var passwordHash string
-	var orgSuspended bool
+	var orgSuspended, membershipSuspended bool
 	var suspended int
 	var passive, activated sql.NullBool
-	err = CheckNotFound(row.Scan(&passwordHash, &u.Id, &u.Uid, &u.Username, &u.Email, &u.FirstName, &u.LastName, &u.Phone,
-		&u.OrgId, &u.Created, &u.Updated, &u.Role, &suspended, &orgSuspended, &passive, &activated))
+	err := CheckNotFound(row.Scan(&passwordHash, &u.Id, &u.Uid, &u.Username, &u.Email, &u.FirstName, &u.LastName, &u.Phone,
+		&u.OrgId, &u.Created, &u.Updated, &u.Role, &suspended, &orgSuspended, &membershipSuspended, &passive, &activated, &u.Version))
 	if err != nil {
 		return nil, "", err
 	}
@@ -286,7 +361,16 @@ func (us *Users) GetByUsername(username string) (*UserWithClaims, string, error)
 		u.Activated = activated.Bool
 	}
 	u.Suspended = suspended > 0
-	c := &UserWithClaims{User: &u, Claims: &Claims{OrgId: u.OrgId, Role: u.Role, OrgSuspended: orgSuspended}}
+	if !orgSuspended && u.OrgId > 0 {
+		orgSuspended, err = ancestorSuspended(us.db, u.OrgId)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if err = us.withAttributes(&u); err != nil {
+		return nil, "", err
+	}
+	c := &UserWithClaims{User: &u, Claims: &Claims{UserId: u.Id, OrgId: u.OrgId, Role: u.Role, OrgSuspended: orgSuspended, MembershipSuspended: membershipSuspended}}
 	return c, passwordHash, err
 }
 
@@ -294,6 +378,7 @@ type SignInParams struct {
 	Email           string `json:"email"`
 	Username        string `json:"username"`
 	Password        string `json:"password"`
+	OrgId           int64  `json:"org_id"` // Optional, the org to act in if not the user's default org.
 	CustomValidator `json:"-"`
 }
 
@@ -320,24 +405,62 @@ func (us *Users) SignIn(p SignInParams) (*UserWithClaims, error) {
 		}
 	}
 	if us.isLocked(p.Username) {
+		var id int64 // Unknown users are audited as 0, as failed sign-ins are.
+		lookupUsers(liveUsers).byName(us.normalizer(), p.Username).row(us.db, "u.id", "users u").Scan(&id)
+		us.audit.record(us.Auditor, AuditSignInLimited, TargetUser, id, nil)
 		return nil, &RateLimitExceededError{Messages: []string{"Too many sign-in attempts try again later."}}
 	}
 	u, hash, err := us.GetByUsername(p.Username)
 	if err != nil {
 		_, ok := err.(*NotFoundError)
 		if ok {
+			us.audit.record(us.Auditor, AuditSignInFailed, TargetUser, 0, nil)
 			return nil, ErrNotAuth
 		}
 		return nil, err
 	}
-	if u.Suspended || u.OrgSuspended || u.Passive {
+	signedIn, err := us.signIn(p, u, hash)
+	if err != nil {
+		if err == ErrNotAuth {
+			us.audit.record(us.Auditor, AuditSignInFailed, TargetUser, u.Id, nil)
+		}
+		return nil, err
+	}
+	// Before hooks only see users who have authenticated.
+	e := &Event{Kind: UserSignedIn, UserId: u.Id, OrgId: signedIn.Claims.OrgId, User: u.User}
+	err = us.emit(e, AuditSignIn, func(tx *sql.Tx) error { return nil })
+	if err != nil {
+		return nil, err
+	}
+	return signedIn, nil
+}
+
+func (us *Users) signIn(p SignInParams, u *UserWithClaims, hash string) (*UserWithClaims, error) {
+	if u.Suspended || u.OrgSuspended || u.MembershipSuspended || u.Passive {
 		Debug("FAILED ATTEMPT:", us.isLocked(p.Username))
 		return nil, ErrNotAuth
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(p.Password))
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(p.Password))
 	if err != nil {
 		return nil, ErrNotAuth
 	}
+	if p.OrgId > 0 && p.OrgId != u.User.OrgId {
+		c, err := us.claimsFor(u.Id, p.OrgId)
+		if err != nil {
+			if _, ok := err.(*NotFoundError); ok {
+				return nil, ErrNotAuth
+			}
+			return nil, err
+		}
+		u.Claims = c
+	}
+	if u.OrgSuspended || u.MembershipSuspended {
+		return nil, ErrNotAuth
+	}
+	u.OrgIds, err = us.memberOrgIds(u.Id)
+	if err != nil {
+		return nil, err
+	}
 	return u, nil
 }
 
@@ -347,7 +470,8 @@ func (us *Users) SignIn(p SignInParams) (*UserWithClaims, error) {
 // 'sliding' they will not usually have to wait the full AuthLockDuration, just until there are no more than 5
 // attempts in last 600 seconds. The effective sign-in rate would thus be 1 'sign in' per minute or one burst of 5
 // 'sign ins' every 5 minutes.
-func (us *Users) isLocked(username string) bool {
+func (us *Users) isLocked(name string) bool {
+	username := us.normalizer().Username(name)
 	stmt, err := us.db.Prepare("INSERT into password_attempts (username, created) values (?, ?)")
 	if err != nil {
 		LogErr(err)
@@ -369,15 +493,39 @@ func (us *Users) isLocked(username string) bool {
 		// Lock the account regardless
 		return true
 	}
+	if count == us.AuthAttempts+1 {
+		us.notifyLockout(name)
+	}
 	return count > us.AuthAttempts
 }
 
+// notifyLockout lets the owner of the account know it has been locked, only the attempt which caused the lock notifies.
+func (us *Users) notifyLockout(username string) {
+	if us.Notifier == nil {
+		return
+	}
+	u, _, err := us.GetByUsername(username)
+	if err != nil || u.Passive {
+		return
+	}
+	notify(us.Notifier, Notification{Kind: NotifyLockout, To: u.Email, User: u.User})
+}
+
 type UpdateUserParams struct {
-	Id              *int64  `json:"id"`
-	FirstName       *string `json:"first_name"`
-	LastName        *string `json:"last_name"`
-	Email           *string `json:"email"`
-	Phone           *string `json:"phone"`
+	Id        *int64  `json:"id"`
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Email     *string `json:"email"`
+	Phone     *string `json:"phone"`
+	Username  *string `json:"username"` // Only when UserOpts.UsernameIsEmail is false.
+	// Moves the user to another default org, their membership of the old default org is replaced. They keep
+	// their role if they're already a member or it can be used in the new org and have no role otherwise. It
+	// can't be 0, Orgs.RemoveMember leaves a user without an org.
+	OrgId      *int64     `json:"org_id"`
+	Attributes Attributes `json:"attributes"` // Only the attributes given are changed, nil values remove them.
+	// Optional, the version of the user the changes were made to. Update returns a ConflictError if the user has
+	// changed since.
+	ExpectedVersion *int64 `json:"expected_version"`
 	CustomValidator `json:"-"`
 }
 
@@ -385,30 +533,187 @@ func (va *UpdateUserParams) Validate() error {
 	if va.CustomValidator != nil {
 		return va.CustomValidator()
 	}
-	if *va.Email != "" && !govalidator.IsEmail(*va.Email) {
-		return ErrInvalid("'email' invalid.")
+	if va.Email != nil && !govalidator.IsEmail(*va.Email) {
+		return ErrEmailInvalid
+	}
+	if va.Username != nil && *va.Username == "" {
+		return ErrUsernameRequired
 	}
 	return nil
 }
 
+// Update changes the fields of the user which are given, see Patch.
 func (us *Users) Update(p UpdateUserParams) error {
+	_, err := us.Patch(p)
+	return err
+}
+
+// Patch writes only the fields of p which are given and differ from the user's, a pointer to "" clears a field.
+// It returns the changes keyed by field, attributes are keyed "attributes.<name>", and writes nothing when
+// there are none.
+func (us *Users) Patch(p UpdateUserParams) (map[string]Change, error) {
+	if p.Id == nil {
+		return nil, ErrNotFound
+	}
+	n := us.normalizer()
+	if p.Email != nil {
+		email := n.Clean(*p.Email)
+		p.Email = &email
+	}
+	if p.Username != nil {
+		username := n.Clean(*p.Username)
+		p.Username = &username
+	}
+	if p.Email != nil && !govalidator.IsEmail(*p.Email) {
+		return nil, ErrEmailInvalid
+	}
+	if p.Username != nil && *p.Username == "" {
+		return nil, ErrUsernameRequired
+	}
 	u, err := us.Get(*p.Id)
 	if err != nil {
+		return nil, err
+	}
+	if us.ConfirmEmailChanges && p.Email != nil && !strings.EqualFold(*p.Email, u.Email) {
+		return nil, ErrEmailChangeUnconfirmed
+	}
+	if p.ExpectedVersion != nil && *p.ExpectedVersion != u.Version {
+		return nil, &ConflictError{Version: u.Version}
+	}
+	attrs, err := us.Attributes.check(p.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	usernameIsEmail := us.UsernameIsEmail != nil && *us.UsernameIsEmail
+	if usernameIsEmail && p.Username != nil {
+		email := u.Email
+		if p.Email != nil {
+			email = *p.Email
+		}
+		if !strings.EqualFold(*p.Username, email) {
+			return nil, ErrUsernameIsEmail
+		}
+		p.Username = nil
+	}
+	pa := newPatch("users", u.Id)
+	pa.str("first_name", &u.FirstName, p.FirstName)
+	pa.str("last_name", &u.LastName, p.LastName)
+	pa.str("email", &u.Email, p.Email)
+	if usernameIsEmail {
+		pa.str("username", &u.Username, p.Email)
+	} else {
+		pa.str("username", &u.Username, p.Username)
+	}
+	pa.str("phone", &u.Phone, p.Phone)
+	_, emailChanged := pa.changes["email"]
+	_, usernameChanged := pa.changes["username"]
+	var emailKey, usernameKey string
+	if emailChanged || usernameChanged {
+		emailKey, usernameKey, err = n.keys(u.Email, u.Username)
+		if err != nil {
+			return nil, err
+		}
+		pa.derived("email_norm", emailKey)
+		pa.derived("username_norm", usernameKey)
+	}
+	oldOrgId := u.OrgId
+	pa.int("org_id", &u.OrgId, p.OrgId)
+	pa.attributes(u.Attributes, attrs)
+	if pa.empty() {
+		return pa.changes, nil
+	}
+	u.Attributes = u.Attributes.merge(pa.attrs)
+	err = us.emit(&Event{Kind: UserUpdated, UserId: u.Id, User: u, Changes: pa.changes}, AuditUserUpdate, func(tx *sql.Tx) error {
+		if u.OrgId != oldOrgId {
+			role, err := movedRole(tx, u)
+			if err != nil {
+				return err
+			}
+			if role != u.Role {
+				pa.set("role", u.Role, role)
+				u.Role = role
+			}
+		}
+		if emailChanged {
+			if err := taken(tx, "email_norm", emailKey, u.Id, ErrEmailTaken); err != nil {
+				return err
+			}
+		}
+		if usernameChanged {
+			if err := taken(tx, "username_norm", usernameKey, u.Id, ErrUsernameTaken); err != nil {
+				return err
+			}
+		}
+		// The checks can still race another transaction, the unique indexes decide.
+		err := takenErr(pa.exec(tx, p.ExpectedVersion))
+		if err != nil {
+			return err
+		}
+		u.Version++
+		if u.OrgId != oldOrgId {
+			if err = moveMembership(tx, u.Id, oldOrgId, u.OrgId, u.Role); err != nil {
+				return err
+			}
+		}
+		if err = userAttrs.save(tx, u.Id, pa.attrs); err != nil {
+			return err
+		}
+		return indexUser(tx, u.Id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pa.changes, nil
+}
+
+// movedRole checks the org u is moving to exists and returns the role they will have in it. An existing
+// membership of the org keeps its role, otherwise u's role is kept when the org has it.
+func movedRole(q queryer, u *User) (Role, error) {
+	var count int64
+	err := q.QueryRow("SELECT count(id) FROM orgs WHERE id = ? AND deleted = 0", u.OrgId).Scan(&count)
+	if err != nil {
+		return RoleNone, err
+	}
+	if count == 0 {
+		return RoleNone, ErrOrgInvalid
+	}
+	role := u.Role
+	err = q.QueryRow("SELECT role FROM memberships WHERE user_id = ? AND org_id = ?", u.Id, u.OrgId).Scan(&role)
+	if err == sql.ErrNoRows {
+		if err = checkRole(q, u.Role, u.OrgId); err == ErrRoleNotFound {
+			role, err = RoleNone, nil
+		}
+	}
+	return role, err
+}
+
+// takenErr returns ErrUsernameTaken or ErrEmailTaken for a violation of the unique index of username_norm or
+// email_norm, other errors are returned as they are.
+func takenErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "Duplicate entry") && !strings.Contains(msg, "UNIQUE constraint") {
 		return err
 	}
-	_ = ApplyUpdates(u, p)
-	if p.Email != nil && us.UsernameIsEmail != nil && *us.UsernameIsEmail {
-		u.Username = *p.Email
+	if strings.Contains(msg, "username_norm") || strings.Contains(msg, "UC_UsernameNorm") {
+		return ErrUsernameTaken
 	}
-	stmt, err := us.db.Prepare("UPDATE users SET first_name = ?, last_name = ?, email = ?, username = ?, phone = ?, updated = ? WHERE id = ? AND deleted = 0")
+	return ErrEmailTaken
+}
+
+// taken returns errTaken if another user has the normalized value in the column.
+func taken(q queryer, column string, value string, id int64, errTaken error) error {
+	var count int64
+	err := q.QueryRow("SELECT count(id) FROM users WHERE "+column+" = ? AND id <> ?", value, id).Scan(&count)
 	if err != nil {
 		return err
 	}
-	err = CheckUpdated(stmt.Exec(u.FirstName, u.LastName, u.Email, u.Username, u.Phone, Milliseconds(time.Now()), u.Id))
-	if err != nil && strings.Contains(err.Error(), "Duplicate entry") { // ERR_STRING_EMAIL_CONSTRAINT) {
-		return ErrEmailTaken
+	if count > 0 {
+		return errTaken
 	}
-	return err
+	return nil
 }
 
 type AssignRoleParams struct {
@@ -433,26 +738,87 @@ func (us *Users) AssignRole(p AssignRoleParams) error {
 		return err
 	}
 	if u.Passive {
-		return ErrInvalid("This user is passive, cannot assign a role")
+		return ErrUserPassive
 	}
-	stmt, err := us.db.Prepare("UPDATE users SET role = ?, updated = ? WHERE id = ? AND deleted = 0")
-	if err != nil {
-		return err
+	if p.Role != nil {
+		if err = checkRole(us.db, *p.Role, u.OrgId); err != nil {
+			return err
+		}
 	}
+	before := u.Role
 	if p.Role == nil {
 		u.Role = 0
 	} else {
 		u.Role = *p.Role
 	}
-	return CheckUpdated(stmt.Exec(u.Role, Milliseconds(time.Now()), u.Id))
+	e := &Event{Kind: RoleAssigned, UserId: u.Id, OrgId: u.OrgId, User: u, Changes: roleChange(before, u.Role)}
+	return us.emit(e, AuditAssignRole, func(tx *sql.Tx) error {
+		err := CheckUpdated(tx.Exec("UPDATE users SET role = ?, updated = ?, version = version + 1 WHERE id = ? AND deleted = 0", u.Role, Milliseconds(time.Now()), u.Id))
+		if err != nil {
+			return err
+		}
+		// The user's role is their role in their default org.
+		_, err = tx.Exec("UPDATE memberships SET role = ? WHERE user_id = ? AND org_id = ?", u.Role, u.Id, u.OrgId)
+		return err
+	})
 }
 
 func (us *Users) Delete(id int64) error {
-	stmt, err := us.db.Prepare("UPDATE users SET deleted = 1, updated = ? WHERE id = ? AND deleted = 0")
-	if err != nil {
-		return err
-	}
-	return CheckUpdated(stmt.Exec(Milliseconds(time.Now()), id))
+	return us.emit(&Event{Kind: UserDeleted, UserId: id}, AuditUserDelete, func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare("UPDATE users SET deleted = 1, deleted_by_org = 0, deleted_at = ?, updated = ?, version = version + 1 WHERE id = ? AND deleted = 0")
+		if err != nil {
+			return err
+		}
+		now := Milliseconds(time.Now())
+		err = CheckUpdated(stmt.Exec(now, now, id))
+		if err != nil {
+			return err
+		}
+		if err = releaseKeys(tx, "id = ?", id); err != nil {
+			return err
+		}
+		return indexUser(tx, id)
+	})
+}
+
+func (us *Users) Suspend(id int64) error {
+	return us.emit(&Event{Kind: UserSuspended, UserId: id}, AuditUserSuspend, func(tx *sql.Tx) error {
+		if err := us.Suspender.set(tx, "suspended", 1, id); err != nil {
+			return err
+		}
+		return clearCascade(tx, "suspended_by_org", id)
+	})
+}
+
+func (us *Users) Restore(id int64) error {
+	return us.emit(&Event{Kind: UserRestored, UserId: id}, AuditUserRestore, func(tx *sql.Tx) error {
+		if err := us.Suspender.set(tx, "suspended", 0, id); err != nil {
+			return err
+		}
+		return clearCascade(tx, "suspended_by_org", id)
+	})
+}
+
+func (us *Users) UnDelete(id int64) error {
+	return us.emit(&Event{Kind: UserUnDeleted, UserId: id}, AuditUserUnDelete, func(tx *sql.Tx) error {
+		if err := claimKeys(tx, us.normalizer(), id); err != nil {
+			return err
+		}
+		if err := us.Suspender.unDelete(tx, id); err != nil {
+			return err
+		}
+		if err := clearCascade(tx, "deleted_by_org", id); err != nil {
+			return err
+		}
+		return indexUser(tx, id)
+	})
+}
+
+// clearCascade forgets that the user's org suspended or deleted them, column is suspended_by_org or deleted_by_org,
+// so restoring or undeleting the org leaves a user who has since been changed individually as they are.
+func clearCascade(tx *sql.Tx, column string, id int64) error {
+	_, err := tx.Exec("UPDATE users SET "+column+" = 0 WHERE id = ?", id)
+	return err
 }
 
 type ListUsersParams struct {
@@ -462,16 +828,19 @@ type ListUsersParams struct {
 }
 
 type UserFilters struct {
-	OrgId     int64  `schema:"org_id"` // sort by org name
-	Role      int64  `schema:"role"`
-	Name      string `schema:"name"` // first name
-	Email     string `schema:"email"`
-	Suspended *bool  `schema:"suspended"`
-	Phone     string `schema:"phone"`
+	OrgId      int64   `schema:"org_id"`       // sort by org name
+	UnderOrgId int64   `schema:"under_org_id"` // Members of the org or any of its descendants.
+	Role       int64   `schema:"role"`
+	Name       string  `schema:"name"` // first name
+	Email      string  `schema:"email"`
+	Suspended  *bool   `schema:"suspended"`
+	Phone      string  `schema:"phone"`
+	Where      *Filter `schema:"-" json:"where"` // Conditions on UserFilterFields, e.g. And(Eq("suspended", true), In("org_id", 4, 7)).
 }
 
 type UserListResponse struct {
 	ListArgs
+	Cursors
 	Total int64   `json:"total"`
 	Items []*User `json:"items"`
 }
@@ -483,10 +852,29 @@ func (va *ListUsersParams) Validate() error {
 	return nil
 }
 
+// Erased users have no username or email and users without an org no org name, they're selected as "" so that
+// sorting and cursors compare them like any other value.
+const listedUserCols = "u.id, u.uid, COALESCE(u.username, '') AS username, COALESCE(u.email, '') AS email," +
+	" u.first_name, u.last_name, u.phone, u.org_id, COALESCE(o.name, '') AS org_name, u.created, u.updated, u.role, u.suspended, u.passive, u.activated, u.version"
+
+func scanListedUser(rows *sql.Rows) (*User, error) {
+	u := &User{}
+	var passive, activated sql.NullBool
+	err := rows.Scan(&u.Id, &u.Uid, &u.Username, &u.Email, &u.FirstName, &u.LastName, &u.Phone, &u.OrgId, &u.OrgName, &u.Created, &u.Updated, &u.Role, &u.Suspended, &passive, &activated, &u.Version)
+	if err != nil {
+		return nil, err
+	}
+	if passive.Valid {
+		u.Passive = passive.Bool
+	}
+	if activated.Valid {
+		u.Activated = activated.Bool
+	}
+	return u, nil
+}
+
 func (us *Users) List(p ListUsersParams) (*UserListResponse, error) {
-	q := "SELECT u.id, u.uid, u.username, u.email, u.first_name, u.last_name, u.phone," +
-		" u.org_id, o.name as org_name, u.created, u.updated, u.role, u.suspended, u.passive, u.activated " +
-		"From users u left join orgs o on u.org_id = o.id WHERE 1"
+	q := "SELECT " + listedUserCols + " From users u left join orgs o on u.org_id = o.id WHERE 1"
 	countq := "SELECT count(u.id) FROM users u WHERE 1"
 
 	args := []interface{}{}
@@ -495,7 +883,18 @@ func (us *Users) List(p ListUsersParams) (*UserListResponse, error) {
 		countq += " AND u.deleted = 0"
 	}
 	if p.OrgId > 0 {
-		q, countq, args = addClause(q, countq, " AND u.org_id = ?", args, p.OrgId)
+		q, countq, args = addClause(q, countq, " AND (u.org_id = ?", args, p.OrgId)
+		q, countq, args = addClause(q, countq, " OR u.id IN (SELECT user_id FROM memberships WHERE org_id = ?))", args, p.OrgId)
+	}
+	if p.UnderOrgId > 0 {
+		ids, err := subtreeIds(us.db, p.UnderOrgId)
+		if err != nil {
+			return nil, err
+		}
+		q, countq, args = addInClause(q, countq, " AND (u.org_id IN ", args, ids)
+		q, countq, args = addInClause(q, countq, " OR u.id IN (SELECT user_id FROM memberships WHERE org_id IN ", args, ids)
+		q += "))"
+		countq += "))"
 	}
 	if p.Role > 0 {
 		q, countq, args = addClause(q, countq, " AND u.role = ?", args, p.Role)
@@ -519,48 +918,55 @@ func (us *Users) List(p ListUsersParams) (*UserListResponse, error) {
 	if p.Email != "" {
 		q, countq, args = addClause(q, countq, " AND u.email like ?", args, "%"+p.Email+"%")
 	}
-	rows, err := GetRows(us.db, q, &p.ListArgs, args...)
+	q, countq, args, err := addFilter(q, countq, args, p.Where, userAttrs.filterFields(UserFilterFields, us.Attributes, "u.id"))
 	if err != nil {
 		return nil, err
 	}
-	row := us.db.QueryRow(countq, args...)
-	var total int64
-	err = row.Scan(&total)
+	rows, err := GetPage(us.db, q, UserSortFields, &p.ListArgs, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+	total := int64(-1)
+	if !p.SkipCount {
+		err = us.db.QueryRow(countq, args...).Scan(&total)
+		if err != nil {
+			return nil, err
+		}
+	}
 	users := []*User{}
 	for rows.Next() {
-		u := &User{}
-		var orgName sql.NullString
-		var passive, activated sql.NullBool
-		err2 := rows.Scan(&u.Id, &u.Uid, &u.Username, &u.Email, &u.FirstName, &u.LastName, &u.Phone, &u.OrgId, &orgName, &u.Created, &u.Updated, &u.Role, &u.Suspended, &passive, &activated)
-		if err2 != nil {
+		u, err := scanListedUser(rows)
+		if err != nil {
 			return nil, err
 		}
-		if passive.Valid {
-			u.Passive = passive.Bool
-		}
-		if activated.Valid {
-			u.Activated = activated.Bool
-		}
-		if orgName.Valid {
-			u.OrgName = orgName.String
-		}
 		users = append(users, u)
 	}
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
+	if err = us.withAttributes(users...); err != nil {
+		return nil, err
+	}
+	var cs Cursors
+	if len(users) > 0 {
+		cs, err = pageCursors(p.ListArgs, UserSortFields, users[0], users[len(users)-1], len(users))
+		if err != nil {
+			return nil, err
+		}
+	}
 	return &UserListResponse{
-		Total: total,
-		Items: users,
+		Total:   total,
+		Items:   users,
+		Cursors: cs,
 		ListArgs: ListArgs{
 			Size:      p.Size,
 			Page:      p.Page,
 			Direction: p.Direction,
 			OrderBy:   p.OrderBy,
 			Deleted:   p.Deleted,
+			Cursor:    p.Cursor,
+			SkipCount: p.SkipCount,
 		}}, nil
 }
 
@@ -570,6 +976,17 @@ func addClause(sqla string, sqlb string, clause string, params []interface{}, va
 	return sqla, sqlb, append(params, val)
 }
 
+// addInClause appends clause followed by a placeholder list e.g. ' AND id IN (?,?,?)'.
+func addInClause(sqla string, sqlb string, clause string, params []interface{}, vals []int64) (string, string, []interface{}) {
+	clause += "(" + strings.TrimSuffix(strings.Repeat("?,", len(vals)), ",") + ")"
+	sqla += clause
+	sqlb += clause
+	for _, v := range vals {
+		params = append(params, v)
+	}
+	return sqla, sqlb, params
+}
+
 type ResetPasswordParams struct {
 	Email           string `json:"email"`
 	CustomValidator `json:"-"`
@@ -593,29 +1010,52 @@ func (us *Users) ResetPassword(p ResetPasswordParams) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	var token string
+	err = us.emit(&Event{Kind: PasswordResetRequested, UserId: u.Id, User: u.User}, AuditPasswordReset, func(tx *sql.Tx) error {
+		token, err = us.issueResetToken(tx, u)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	notify(us.Notifier, Notification{Kind: NotifyPasswordReset, To: u.Email, User: u.User, Token: token})
+	return token, nil
+}
+
+func (us *Users) resetPassword(email string) (*UserWithClaims, string, error) {
+	u, _, err := us.GetByUsername(email)
+	if err != nil {
+		return nil, "", err
+	}
+	var token string
+	err = Tx(us.db, func(tx *sql.Tx) error {
+		token, err = us.issueResetToken(tx, u)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return u, token, nil
+}
+
+func (us *Users) issueResetToken(tx *sql.Tx, u *UserWithClaims) (string, error) {
 	if u.Passive {
 		return "", ErrNotAuth
 	}
 	token := us.PassGen(128)
-	err = Tx(us.db, func(tx *sql.Tx) error {
-		_, err = tx.Exec("UPDATE password_resets set deleted = 1 where email = ?", p.Email)
-		if err != nil {
-			return err
-		}
-		stmt, err := tx.Prepare("INSERT into password_resets (user_id, email, reset_token, created, deleted) values (?, ?, ?, ?, ?)")
-		if err != nil {
-			return err
-		}
-		_, err = stmt.Exec(u.Id, u.Email, token, Milliseconds(time.Now()), 0)
-		if err != nil {
-			LogErr(err)
-			return err
-		}
-		return nil
-	})
+	_, err := tx.Exec("UPDATE password_resets set deleted = 1 where user_id = ?", u.Id)
 	if err != nil {
 		return "", err
 	}
+	stmt, err := tx.Prepare("INSERT into password_resets (user_id, email, reset_token, created, deleted) values (?, ?, ?, ?, ?)")
+	if err != nil {
+		return "", err
+	}
+	_, err = stmt.Exec(u.Id, u.Email, token, Milliseconds(time.Now()), 0)
+	if err != nil {
+		LogErr(err)
+		return "", err
+	}
 	return token, nil
 }
 
@@ -650,17 +1090,37 @@ func (va *ChangePasswordParams) Validate() error {
 }
 
 func (us *Users) ChangePassword(p ChangePasswordParams) error {
+	emailKey, err := us.normalizer().Email(p.Email)
+	if err != nil {
+		return err
+	}
 	if p.ExistingPassword != "" {
-		_, err := us.SignIn(SignInParams{Username: p.Email, Password: p.ExistingPassword})
+		// Checked directly rather than with SignIn, which would emit a sign-in, but guesses count towards the
+		// same lock.
+		if us.isLocked(p.Email) {
+			return &RateLimitExceededError{Messages: []string{"Too many sign-in attempts try again later."}}
+		}
+		var hash string
+		err := CheckNotFound(lookupUsers(activeUsers).where("u.email_norm = ? AND COALESCE(u.passive, 0) = 0", emailKey).
+			row(us.db, "COALESCE(u.password_hash, '')", "users u").Scan(&hash))
+		if _, ok := err.(*NotFoundError); ok {
+			return ErrNotAuth
+		}
 		if err != nil {
 			return err
 		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(p.ExistingPassword)) != nil {
+			return ErrNotAuth
+		}
 	} else if p.ResetToken != "" {
 		err := Tx(us.db, func(tx *sql.Tx) error {
 			stmt, err := tx.Prepare(
-				"SELECT reset_token, created FROM password_resets where email = ? and  deleted = 0 " +
-					"ORDER BY created DESC LIMIT 1")
-			row := stmt.QueryRow(p.Email)
+				"SELECT r.reset_token, r.created FROM password_resets r JOIN users u ON r.user_id = u.id " +
+					"WHERE u.email_norm = ? AND r.deleted = 0 ORDER BY r.created DESC LIMIT 1")
+			if err != nil {
+				return err
+			}
+			row := stmt.QueryRow(emailKey)
 			var resetToken string
 			var created int64
 			err = CheckNotFound(row.Scan(&resetToken, &created))
@@ -673,7 +1133,7 @@ func (us *Users) ChangePassword(p ChangePasswordParams) error {
 			if Milliseconds(time.Now()) > (created + us.ResetTokenExpiry*1000) {
 				return ErrTokenExpired
 			}
-			_, err = tx.Exec("UPDATE password_resets set deleted = 1 WHERE email = ?", p.Email)
+			_, err = tx.Exec("UPDATE password_resets set deleted = 1 WHERE user_id IN (SELECT id FROM users WHERE email_norm = ?)", emailKey)
 			return err
 		})
 		if err != nil {
@@ -686,21 +1146,31 @@ func (us *Users) ChangePassword(p ChangePasswordParams) error {
 	if err != nil {
 		return err
 	}
-	stmt, err := us.db.Prepare("UPDATE users SET activated = 1, password_hash = ?, updated = ? WHERE email = ? AND deleted = 0")
-	err = CheckNotFound(err)
+	var id int64
+	err = CheckNotFound(lookupUsers(liveUsers).where("u.email_norm = ?", emailKey).row(us.db, "u.id", "users u").Scan(&id))
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(hash, Milliseconds(time.Now()), p.Email)
-	return nil
+	return us.emit(&Event{Kind: PasswordChanged, UserId: id}, AuditPasswordChange, func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare("UPDATE users SET activated = 1, password_hash = ?, updated = ?, version = version + 1 WHERE id = ? AND deleted = 0")
+		err = CheckNotFound(err)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.Exec(hash, Milliseconds(time.Now()), id)
+		return err
+	})
 }
 
+const userCols = "u.id, u.uid, u.username, u.email, u.first_name, u.last_name, u.phone, u.org_id, u.created, u.updated," +
+	" u.role, u.suspended, u.passive, u.activated, u.version"
+
 func scanUser(row *sql.Row) (*User, error) {
 	var u User
 	var suspended int
 	var passive, activated sql.NullBool
 	err := row.Scan(&u.Id, &u.Uid, &u.Username, &u.Email, &u.FirstName, &u.LastName, &u.Phone, &u.OrgId,
-		&u.Created, &u.Updated, &u.Role, &suspended, &passive, &activated)
+		&u.Created, &u.Updated, &u.Role, &suspended, &passive, &activated, &u.Version)
 	u.Suspended = suspended > 0
 	if passive.Valid {
 		u.Passive = passive.Bool
@@ -7,13 +7,17 @@ import (
 	"time"
 )
 
-var cp = SignUpParams{Email: "user@mail.com", OrgId: 1}
+var cp = SignUpParams{Email: "user@mail.com"}
 
 func TestUsers_SignUp(t *testing.T) {
+	o, err := orgsv.Create(CreateOrgParams{Name: "Sign Up Inc."})
+	assert.Nil(t, err)
+	cp = SignUpParams{Email: "user@mail.com", OrgId: o.Id}
 	u, _, err := us.SignUp(cp)
 	assert.Nil(t, err)
 	assert.Equal(t, u.Email, cp.Email)
 	assert.True(t, u.Id > 0)
+	first := u.Id
 
 	// Should not allow create for existing email
 	_, _, err = us.SignUp(cp)
@@ -26,46 +30,50 @@ func TestUsers_SignUp(t *testing.T) {
 	assert.Equal(t, false, u.Activated)
 
 	// List
-	users, err := us.List(ListUsersParams{UserFilters: UserFilters{OrgId: 1}})
+	users, err := us.List(ListUsersParams{UserFilters: UserFilters{OrgId: o.Id}})
 	assert.Nil(t, err)
 	assert.Equal(t, 1, len(users.Items))
 	assert.Equal(t, int64(1), users.Total)
-	assert.Equal(t, int64(1), users.Items[0].Id)
+	assert.Equal(t, first, users.Items[0].Id)
 	assert.Equal(t, cp.Email, users.Items[0].Email)
 	assert.Equal(t, false, users.Items[0].Passive)
 
 	i := 5
 	for i > 0 {
-		u, _, err = us.SignUp(SignUpParams{Email: fmt.Sprintf("%d@mail.com", i)})
+		u, _, err = us.SignUp(SignUpParams{Email: fmt.Sprintf("%d@mail.com", i), OrgId: o.Id})
 		assert.Nil(t, err)
 		i--
 	}
 	users, err = us.List(ListUsersParams{
-		ListArgs: ListArgs{Size: 3},
+		ListArgs:    ListArgs{Size: 3},
+		UserFilters: UserFilters{OrgId: o.Id},
 	})
 	assert.Equal(t, 3, len(users.Items))
 	assert.Equal(t, int64(6), users.Total)
 
 	// 2nd Page shorter than size
 	users, err = us.List(ListUsersParams{
-		ListArgs: ListArgs{Size: 4, Page: 1, OrderBy: "id", Direction: DirectionAsc},
+		ListArgs:    ListArgs{Size: 4, Page: 1, OrderBy: "id", Direction: DirectionAsc},
+		UserFilters: UserFilters{OrgId: o.Id},
 	})
 	assert.Nil(t, err)
 	assert.Equal(t, 2, len(users.Items))
 
 	// Order by id desc
 	users, err = us.List(ListUsersParams{
-		ListArgs: ListArgs{Size: 20, Page: 0, OrderBy: "id", Direction: DirectionDesc},
+		ListArgs:    ListArgs{Size: 20, Page: 0, OrderBy: "id", Direction: DirectionDesc},
+		UserFilters: UserFilters{OrgId: o.Id},
 	})
 	assert.Nil(t, err)
-	assert.Equal(t, int64(6), users.Items[0].Id)
+	assert.Equal(t, u.Id, users.Items[0].Id)
 
 	// Order by id asc
 	users, err = us.List(ListUsersParams{
-		ListArgs: ListArgs{Size: 20, Page: 0, OrderBy: "id", Direction: DirectionAsc},
+		ListArgs:    ListArgs{Size: 20, Page: 0, OrderBy: "id", Direction: DirectionAsc},
+		UserFilters: UserFilters{OrgId: o.Id},
 	})
 	assert.Nil(t, err)
-	assert.Equal(t, int64(1), users.Items[0].Id)
+	assert.Equal(t, first, users.Items[0].Id)
 }
 
 func TestUsers_Passive(t *testing.T){
@@ -104,8 +112,6 @@ func TestUsers_Passive(t *testing.T){
 	cp.Passive = false
 }
 
-
-
 func TestUsers_Update(t *testing.T) {
 	cp.Email = "update@mail.com"
 	u, _, err := us.SignUp(cp)
@@ -164,6 +170,9 @@ func TestUsers_AssignRole(t *testing.T) {
 	assert.Nil(t, err)
 	role := Role(55)
 	err = us.AssignRole(AssignRoleParams{Id: &u.Id, Role: &role})
+	assert.Equal(t, ErrRoleNotFound, err)
+	role = RoleAdmin
+	err = us.AssignRole(AssignRoleParams{Id: &u.Id, Role: &role})
 	assert.Nil(t, err)
 	u, err = us.Get(u.Id)
 	assert.Nil(t, err)
@@ -176,6 +185,9 @@ func TestUsers_AssignRole(t *testing.T) {
 }
 
 func TestUsers_SignIn(t *testing.T) {
+	o, err := orgsv.Create(CreateOrgParams{Name: "Sign In Inc."})
+	assert.Nil(t, err)
+	cp.OrgId = o.Id
 	// With a given password
 	cp.Email = "given-pword@mail.com"
 	cp.Password = "M0nk3yNutz5"
@@ -226,7 +238,6 @@ func TestUsers_SignIn(t *testing.T) {
 	sususer, err = us.Get(id)
 	assert.Nil(t, err)
 	assert.Equal(t, false, sususer.Suspended)
-	// TASK: create organisation within this test so we don't have to run whole suite. It does fail at the moment if not.
 	assert.Nil(t, orgsv.Suspend(cp.OrgId))
 	_, err = us.SignIn(SignInParams{Username: cp.Email, Password: newPass})
 	assert.Error(t, err)
@@ -296,3 +307,41 @@ func TestUsers_PasswordReset(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, email, uc.Email)
 }
+
+func TestUsers_ChangePassword_NoSignIn(t *testing.T) {
+	password := "M0nk3yNutz5"
+	al := NewAuditLog(us.db)
+	ua := us.By(0, "10.0.0.5")
+	ua.Auditor = al
+	signedIn := 0
+	ua.Hooks = NewHooks()
+	ua.After(func(e Event) { signedIn++ }, UserSignedIn)
+	u, _, err := ua.SignUp(SignUpParams{Email: "change-no-sign-in@mail.com", Password: password})
+	assert.Nil(t, err)
+
+	assert.Equal(t, ErrNotAuth, ua.ChangePassword(ChangePasswordParams{Email: u.Email, ExistingPassword: "wrong", NewPassword: "M0nk3yNutz6"}))
+	assert.Nil(t, ua.ChangePassword(ChangePasswordParams{Email: u.Email, ExistingPassword: password, NewPassword: "M0nk3yNutz6"}))
+	ua.Hooks.Wait()
+	assert.Equal(t, 0, signedIn)
+	res, err := al.List(ListAuditParams{AuditFilters: AuditFilters{TargetType: TargetUser, TargetId: u.Id}, ListArgs: ListArgs{Direction: DirectionAsc}})
+	assert.Nil(t, err)
+	actions := []string{}
+	for _, e := range res.Items {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{AuditSignUp, AuditPasswordChange}, actions)
+}
+
+func TestUsers_ChangePassword_Locked(t *testing.T) {
+	password := "M0nk3yNutz5"
+	ul := NewUsers(us.db, UserOpts{AuthAttempts: 5, AuthLockDuration: 60})
+	u, _, err := ul.SignUp(SignUpParams{Email: "change-locked@mail.com", Password: password})
+	assert.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, ErrNotAuth, ul.ChangePassword(ChangePasswordParams{Email: u.Email, ExistingPassword: "wrong", NewPassword: "M0nk3yNutz6"}))
+	}
+	err = ul.ChangePassword(ChangePasswordParams{Email: u.Email, ExistingPassword: password, NewPassword: "M0nk3yNutz6"})
+	assert.IsType(t, &RateLimitExceededError{}, err)
+	_, err = ul.SignIn(SignInParams{Email: u.Email, Password: password})
+	assert.IsType(t, &RateLimitExceededError{}, err)
+}